@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/subtle"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Shared secret required in the X-API-Key header, auth is disabled when
+// it's unset
+var apiKey string = os.Getenv("API_KEY")
+
+// Creates the API key middleware, or nil when API_KEY isn't set. Requests
+// without the matching key get a 401.
+func apiKeyMiddleware() gin.HandlerFunc {
+	if apiKey == "" {
+		return nil
+	}
+
+	return func(c *gin.Context) {
+		// Constant-time so the key can't be guessed from response timings
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-API-Key")), []byte(apiKey)) != 1 {
+			c.AbortWithStatusJSON(401, gin.H{"error": "missing or invalid api key"})
+			return
+		}
+
+		c.Next()
+	}
+}
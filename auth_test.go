@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	setConfig(t, &apiKey, "secret")
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"missing", "", 401},
+		{"wrong", "guess", 401},
+		{"correct", "secret", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-Key", tt.key)
+			}
+			if w := serveRequest(req); w.Code != tt.status {
+				t.Errorf("got status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+
+	// The probes don't need a key
+	if w := get("/healthz"); w.Code != 200 {
+		t.Errorf("got status %d for /healthz, want 200", w.Code)
+	}
+}
+
+func TestAPIKeyDisabled(t *testing.T) {
+	setConfig(t, &apiKey, "")
+
+	if w := get("/geo/zip?ip=81.2.69.142"); w.Code != 200 {
+		t.Errorf("got status %d without API_KEY, want 200", w.Code)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of IPs accepted by a single batch request
+var maxBatchSize = envInt("MAX_BATCH_SIZE", 1000)
+
+// Maximum size in bytes of a batch request body, so a huge body can't
+// exhaust memory before MAX_BATCH_SIZE is checked
+var maxBodyBytes = int64(envInt("MAX_BODY_BYTES", 1<<20))
+
+// Content type of a batch streamed as one JSON result per line
+const ndjsonContentType = "application/x-ndjson"
+
+// Content type of a batch streamed as CSV rows
+const csvContentType = "text/csv"
+
+// Columns of a batch streamed as CSV
+var csvHeader = []string{"ip", "country_code", "city", "lat", "lon", "zip", "error"}
+
+// How many results are written between flushes of a streamed batch
+const ndjsonFlushEvery = 100
+
+// BatchRequest is the body accepted by `/geo/batch`
+type BatchRequest struct {
+	IPs []string `json:"ips"`
+}
+
+// BatchResult is the lookup result for a single IP of a batch. Either the
+// geo fields or `error` is set.
+type BatchResult struct {
+	IP    string `json:"ip" xml:"ip,attr"`
+	Error string `json:"error,omitempty" xml:"error,omitempty"`
+	*GeoResponse
+}
+
+// Checks the number of IPs requested is within MAX_BATCH_SIZE. If it
+// isn't, the request is ended with a 413 and false is returned.
+func checkBatchSize(c *gin.Context, count int) bool {
+	if count > maxBatchSize {
+		c.AbortWithStatusJSON(413, gin.H{
+			"error": fmt.Sprintf("batch exceeds the maximum of %d ips", maxBatchSize),
+		})
+		return false
+	}
+	return true
+}
+
+// Looks up an IP given as a string. When it's invalid or the lookup fails,
+// the error to report for it is returned instead.
+func lookupIPString(ctx context.Context, value string) (*cityRecord, string) {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, "invalid ip"
+	}
+	ip = normalizeIP(ip)
+	if rejectIP(ip) {
+		return nil, "private or reserved ip"
+	}
+
+	record, err := lookupCity(ctx, ip)
+	if errors.Is(err, errNotFound) {
+		return nil, err.Error()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, "lookup timed out"
+	}
+	if errors.Is(err, errNoDatabase) {
+		return nil, "database not loaded"
+	}
+	if err != nil {
+		slog.Error("city lookup failed", "ip", ip.String(), "error", err)
+		return nil, "lookup failed"
+	}
+
+	return record, ""
+}
+
+// Looks up a single IP of a batch, reporting failures on the result
+// rather than failing the whole request
+func lookupBatchIP(ctx context.Context, value string, lang string) BatchResult {
+	result := BatchResult{IP: value}
+
+	record, errMsg := lookupIPString(ctx, value)
+	if errMsg != "" {
+		result.Error = errMsg
+		return result
+	}
+
+	response := newLookupGeoResponse(record, lang)
+	result.GeoResponse = &response
+	return result
+}
+
+// Reads the IPs of a batch from the request body, within MAX_BODY_BYTES
+// and MAX_BATCH_SIZE. If the body is invalid or too large, the request is
+// ended and the second parameter returned is false.
+func bindBatchRequest(c *gin.Context) (BatchRequest, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodyBytes)
+
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(413, gin.H{
+				"error": fmt.Sprintf("request body exceeds the maximum of %d bytes", maxBodyBytes),
+			})
+			return req, false
+		}
+
+		c.AbortWithStatusJSON(400, gin.H{"error": "invalid request body"})
+		return req, false
+	}
+
+	if !checkBatchSize(c, len(req.IPs)) {
+		return req, false
+	}
+	return req, true
+}
+
+// Looks up every IP in the request body, returning the results in the
+// same order as the input
+func batchHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindBatchRequest(c)
+	if !ok {
+		return
+	}
+
+	// Streams are written as they go rather than through `render`, so the
+	// schema is checked before they start
+	keys, ok := getSchema(c)
+	if !ok {
+		return
+	}
+
+	if strings.Contains(c.GetHeader("Accept"), ndjsonContentType) {
+		streamBatch(c, req.IPs, lang, keys)
+		return
+	}
+	if strings.Contains(c.GetHeader("Accept"), csvContentType) {
+		streamBatchCSV(c, req.IPs, lang, keys)
+		return
+	}
+
+	results := make([]BatchResult, 0, len(req.IPs))
+	for _, value := range req.IPs {
+		results = append(results, lookupBatchIP(c.Request.Context(), value, lang))
+	}
+
+	render(c, 200, results)
+}
+
+// Looks up every IP in the request body and returns how many are in each
+// country and continent rather than the result of each. IPs that are
+// invalid, rejected, or not found are only counted as skipped, and ones
+// with no country as unknown.
+func bulkStatsHandler(c *gin.Context) {
+	req, ok := bindBatchRequest(c)
+	if !ok {
+		return
+	}
+
+	countries := gin.H{}
+	continents := gin.H{}
+	skipped, unknown := 0, 0
+	for _, value := range req.IPs {
+		record, errMsg := lookupIPString(c.Request.Context(), value)
+		if errMsg != "" {
+			skipped++
+			continue
+		}
+
+		if code := record.Country.IsoCode; code != "" {
+			count, _ := countries[code].(int)
+			countries[code] = count + 1
+		} else {
+			unknown++
+		}
+		if code := record.Continent.Code; code != "" {
+			count, _ := continents[code].(int)
+			continents[code] = count + 1
+		}
+	}
+
+	render(c, 200, gin.H{
+		"total":      len(req.IPs),
+		"skipped":    skipped,
+		"unknown":    unknown,
+		"countries":  countries,
+		"continents": continents,
+	})
+}
+
+// Writes the results of a batch as newline-delimited JSON as each IP is
+// resolved, so large batches don't have to be held in memory and clients
+// can process them incrementally
+func streamBatch(c *gin.Context, ips []string, lang string, keys map[string]string) {
+	ctx := c.Request.Context()
+
+	c.Header("Content-Type", ndjsonContentType)
+	c.Status(200)
+
+	encoder := json.NewEncoder(c.Writer)
+	for i, value := range ips {
+		if ctx.Err() != nil {
+			return
+		}
+		if streamShutdown.Err() != nil {
+			encoder.Encode(gin.H{"error": streamShutdownError})
+			return
+		}
+
+		line, err := applySchema(lookupBatchIP(ctx, value, lang), keys)
+		if err == nil {
+			err = encoder.Encode(line)
+		}
+		if err != nil {
+			slog.Warn("failed to write batch result", "error", err)
+			return
+		}
+
+		if (i+1)%ndjsonFlushEvery == 0 {
+			flushStarted(c)
+		}
+	}
+}
+
+// Flushes the response once some of it has been written through. While
+// the gzip middleware is still buffering a response shorter than
+// GZIP_MIN_LENGTH, flushing would corrupt it, and the rest is written out
+// when the request ends anyway.
+func flushStarted(c *gin.Context) {
+	if c.Writer.Size() > 0 {
+		c.Writer.Flush()
+	}
+}
+
+// Writes the results of a batch as CSV rows with a header line as each IP
+// is resolved. IPs that fail have only the `ip` and `error` columns set.
+func streamBatchCSV(c *gin.Context, ips []string, lang string, keys map[string]string) {
+	ctx := c.Request.Context()
+
+	c.Header("Content-Type", csvContentType+"; charset=utf-8")
+	c.Status(200)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(renameColumns(csvHeader, keys))
+	for i, value := range ips {
+		if ctx.Err() != nil {
+			return
+		}
+		if streamShutdown.Err() != nil {
+			writer.Write([]string{"", "", "", "", "", "", streamShutdownError})
+			break
+		}
+
+		writer.Write(csvRow(lookupBatchIP(ctx, value, lang)))
+		if (i+1)%ndjsonFlushEvery == 0 {
+			writer.Flush()
+			flushStarted(c)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		slog.Warn("failed to write batch result", "error", err)
+	}
+}
+
+// Renames the columns of a CSV header to those of the schema
+func renameColumns(header []string, keys map[string]string) []string {
+	renamed := make([]string, len(header))
+	for i, column := range header {
+		if to, ok := keys[column]; ok {
+			column = to
+		}
+		renamed[i] = column
+	}
+	return renamed
+}
+
+// Builds the CSV row of a batch result, in the order of `csvHeader`
+func csvRow(result BatchResult) []string {
+	if result.GeoResponse == nil {
+		return []string{result.IP, "", "", "", "", "", result.Error}
+	}
+
+	return []string{
+		result.IP,
+		result.Country.IsoCode,
+		result.City,
+		strconv.FormatFloat(result.Location.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(result.Location.Longitude, 'f', -1, 64),
+		result.Postal,
+		"",
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchHandler(t *testing.T) {
+	w := post("/geo/batch", "application/json", `{"ips": ["81.2.69.142", "10.0.0.1", "bogus", "216.160.83.56", "3000::1"]}`)
+
+	var results []BatchResult
+	decodeResponse(t, w, 200, &results)
+
+	want := []struct {
+		ip      string
+		country string
+		err     string
+	}{
+		{"81.2.69.142", "GB", ""},
+		{"10.0.0.1", "", "private or reserved ip"},
+		{"bogus", "", "invalid ip"},
+		{"216.160.83.56", "US", ""},
+		{"3000::1", "", "ip not found in database"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.IP != want[i].ip || result.Error != want[i].err {
+			t.Errorf("result %d is %s with error %q, want %s with error %q", i, result.IP, result.Error, want[i].ip, want[i].err)
+		}
+		if want[i].err != "" {
+			if result.GeoResponse != nil {
+				t.Errorf("result %d for %s has a record along with its error", i, result.IP)
+			}
+			continue
+		}
+		if result.GeoResponse == nil || result.Country.IsoCode != want[i].country {
+			t.Errorf("result %d for %s isn't in %s", i, result.IP, want[i].country)
+		}
+	}
+}
+
+func TestBatchHandlerInvalidBody(t *testing.T) {
+	if w := post("/geo/batch", "application/json", `{"ips": "81.2.69.142"}`); w.Code != 400 {
+		t.Errorf("got status %d, want 400", w.Code)
+	}
+}
+
+func TestBatchHandlerTooManyIPs(t *testing.T) {
+	setConfig(t, &maxBatchSize, 1)
+
+	if w := post("/geo/batch", "application/json", `{"ips": ["81.2.69.142", "216.160.83.56"]}`); w.Code != 413 {
+		t.Errorf("got status %d, want 413", w.Code)
+	}
+}
+
+func TestBatchHandlerBodyTooLarge(t *testing.T) {
+	setConfig(t, &maxBodyBytes, 64)
+
+	body := `{"ips": [` + strings.Repeat(`"81.2.69.142", `, 10) + `"81.2.69.142"]}`
+	for _, target := range []string{"/geo/batch", "/geo/bulk-stats"} {
+		w := post(target, "application/json", body)
+		if w.Code != 413 || w.Body.String() != `{"error":"request body exceeds the maximum of 64 bytes"}` {
+			t.Errorf("got %d %s for an oversized body to %s, want a 413", w.Code, w.Body.String(), target)
+		}
+	}
+
+	// A body within the limit is still accepted
+	if w := post("/geo/batch", "application/json", `{"ips": ["81.2.69.142"]}`); w.Code != 200 {
+		t.Errorf("got status %d for a body within the limit, want 200", w.Code)
+	}
+}
+
+// Posts a batch of the IPs accepting the content type
+func postBatch(ips string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/geo/batch", strings.NewReader(`{"ips": [`+ips+`]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	return serveRequest(req)
+}
+
+func TestBatchNDJSON(t *testing.T) {
+	ips := strings.Repeat(`"81.2.69.142", `, 150) + `"bogus"`
+	w := postBatch(ips, ndjsonContentType)
+	if w.Code != 200 {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != ndjsonContentType {
+		t.Errorf("got Content-Type %q, want %s", got, ndjsonContentType)
+	}
+
+	var results []BatchResult
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var result BatchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, result)
+	}
+
+	if len(results) != 151 {
+		t.Fatalf("got %d lines, want 151", len(results))
+	}
+	if results[0].GeoResponse == nil || results[0].Country.IsoCode != "GB" {
+		t.Errorf("got %+v first, want 81.2.69.142 in GB", results[0])
+	}
+	if last := results[150]; last.IP != "bogus" || last.Error != "invalid ip" {
+		t.Errorf("got %+v last, want an error for bogus", last)
+	}
+}
+
+func TestBatchCSV(t *testing.T) {
+	ips := strings.Repeat(`"81.2.69.142", `, 150) + `"bogus"`
+	w := postBatch(ips, csvContentType)
+	if w.Code != 200 {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("got Content-Type %q, want text/csv", got)
+	}
+
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(rows) != 152 {
+		t.Fatalf("got %d rows, want a header and 151 rows", len(rows))
+	}
+	if want := "ip,country_code,city,lat,lon,zip,error"; strings.Join(rows[0], ",") != want {
+		t.Errorf("got header %q, want %q", strings.Join(rows[0], ","), want)
+	}
+	if want := "81.2.69.142,GB,Norwich,52.6259,1.3032,NR1,"; strings.Join(rows[1], ",") != want {
+		t.Errorf("got row %q, want %q", strings.Join(rows[1], ","), want)
+	}
+	if want := "bogus,,,,,,invalid ip"; strings.Join(rows[151], ",") != want {
+		t.Errorf("got row %q for an invalid IP, want %q", strings.Join(rows[151], ","), want)
+	}
+}
+
+func TestBulkStatsHandler(t *testing.T) {
+	w := post("/geo/bulk-stats", "application/json",
+		`{"ips": ["81.2.69.142", "81.2.69.142", "216.160.83.56", "89.160.20.112", "1.0.0.1", "10.0.0.1", "bogus", "3000::1"]}`)
+
+	want := `{"continents":{"EU":3,"NA":1,"OC":1},"countries":{"AU":1,"GB":2,"SE":1,"US":1},"skipped":3,"total":8,"unknown":0}`
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("got %d %s, want 200 %s", w.Code, w.Body.String(), want)
+	}
+}
+
+func TestBulkStatsHandlerUnknownCountry(t *testing.T) {
+	useDatabase(t, openTestDatabase(t, "GeoIP2-City", time.Now(),
+		testNetwork{"81.2.69.0/24", map[string]interface{}{
+			"location": map[string]interface{}{"latitude": 52.6259, "longitude": 1.3032},
+		}},
+	))
+
+	w := post("/geo/bulk-stats", "application/json", `{"ips": ["81.2.69.142"]}`)
+	want := `{"continents":{},"countries":{},"skipped":0,"total":1,"unknown":1}`
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("got %d %s for an IP without a country, want 200 %s", w.Code, w.Body.String(), want)
+	}
+}
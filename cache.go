@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Number of city records to keep in memory, 0 disables the cache
+var cacheSize = envInt("CACHE_SIZE", 10000)
+
+// How long an IP that isn't in the database is remembered as not found,
+// 0 only caches IPs that are found
+var negativeCacheTTL = envDuration("NEGATIVE_CACHE_TTL", time.Minute)
+
+// LRU of city records keyed by IP string, nil when caching is disabled.
+// IPs that weren't found are cached as a `notFoundEntry` instead.
+var cityCache *lru.Cache
+
+// Bumped by every purge, so a lookup that was already running on the old
+// database when it was reloaded can't cache its result after the purge.
+// Held for reading while caching and for writing while purging, so the
+// check and the add can't straddle a purge.
+var (
+	cacheMu         sync.RWMutex
+	cacheGeneration uint64
+)
+
+// Cached in place of a record for an IP that isn't in the database
+type notFoundEntry struct {
+	expires time.Time
+}
+
+// Creates the city record cache if it's enabled
+func initCache() error {
+	if cacheSize <= 0 {
+		return nil
+	}
+
+	var err error
+	cityCache, err = lru.New(cacheSize)
+	return err
+}
+
+// Gets a cached city record for the IP string. A nil record is returned
+// along with true when the IP is cached as not found.
+func getCachedCity(key string) (*cityRecord, bool) {
+	if cityCache == nil {
+		return nil, false
+	}
+
+	if value, ok := cityCache.Get(key); ok {
+		switch value := value.(type) {
+		case *cityRecord:
+			cacheHits.Inc()
+			return value, true
+		case notFoundEntry:
+			if time.Now().Before(value.expires) {
+				cacheHits.Inc()
+				return nil, true
+			}
+			cityCache.Remove(key)
+		}
+	}
+
+	cacheMisses.Inc()
+	return nil, false
+}
+
+// Gets the generation of the cache, to be taken before looking an IP up
+// and passed along when caching the result
+func currentCacheGeneration() uint64 {
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	return cacheGeneration
+}
+
+// Caches the city record for the IP string, unless the cache was purged
+// since the generation was taken
+func setCachedCity(key string, record *cityRecord, generation uint64) {
+	if cityCache == nil {
+		return
+	}
+
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	if generation == cacheGeneration {
+		cityCache.Add(key, record)
+	}
+}
+
+// Caches that the IP string isn't in the database for NEGATIVE_CACHE_TTL,
+// unless the cache was purged since the generation was taken
+func setCachedNotFound(key string, generation uint64) {
+	if cityCache == nil || negativeCacheTTL <= 0 {
+		return
+	}
+
+	cacheMu.RLock()
+	defer cacheMu.RUnlock()
+	if generation == cacheGeneration {
+		cityCache.Add(key, notFoundEntry{expires: time.Now().Add(negativeCacheTTL)})
+	}
+}
+
+// Drops every cached record, including the IPs cached as not found. Must
+// be called whenever the database is reloaded so stale records aren't
+// served, and IPs the new database has aren't still reported missing.
+// Lookups still running on the old database don't cache their results.
+func purgeCache() {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cacheGeneration++
+	if cityCache != nil {
+		cityCache.Purge()
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMappedIPv6CacheKey(t *testing.T) {
+	mapped := normalizeIP(net.ParseIP("::ffff:8.8.8.8")).String()
+	plain := normalizeIP(net.ParseIP("8.8.8.8")).String()
+	if mapped != plain {
+		t.Fatalf("got cache key %s for the IPv4-mapped address and %s for the IPv4 one", mapped, plain)
+	}
+
+	purgeCache()
+	get("/geo?ip=::ffff:81.2.69.142")
+	if record, ok := getCachedCity("81.2.69.142"); !ok || record == nil {
+		t.Error("the lookup of the IPv4-mapped address wasn't cached under the IPv4 address")
+	}
+}
+
+func TestMappedIPv6Results(t *testing.T) {
+	mapped := get("/geo?ip=::ffff:81.2.69.142")
+	plain := get("/geo?ip=81.2.69.142")
+
+	if mapped.Code != 200 || mapped.Body.String() != plain.Body.String() {
+		t.Errorf("got %d %s for the IPv4-mapped address, want %s", mapped.Code, mapped.Body.String(), plain.Body.String())
+	}
+}
+
+// Counts the lookups that reach the database for the duration of the test
+func countLookups(t testing.TB) *atomic.Int64 {
+	t.Helper()
+
+	var lookups atomic.Int64
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		lookups.Add(1)
+		return geoDb.City(ip)
+	})
+	return &lookups
+}
+
+func TestNegativeCache(t *testing.T) {
+	setConfig(t, &negativeCacheTTL, time.Minute)
+	purgeCache()
+	lookups := countLookups(t)
+
+	for i := range 2 {
+		if w := get("/geo/zip?ip=3000::1"); w.Code != 404 {
+			t.Fatalf("got status %d for lookup %d of an unfound IP, want 404", w.Code, i+1)
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Errorf("looked the unfound IP up in the database %d times, want the second served from the cache", got)
+	}
+
+	// Reloading the database forgets the IPs it didn't have
+	purgeCache()
+	get("/geo/zip?ip=3000::1")
+	if got := lookups.Load(); got != 2 {
+		t.Errorf("looked the unfound IP up %d times after a purge, want 2", got)
+	}
+}
+
+func TestNegativeCacheExpiry(t *testing.T) {
+	setConfig(t, &negativeCacheTTL, 10*time.Millisecond)
+	purgeCache()
+	lookups := countLookups(t)
+
+	get("/geo/zip?ip=3000::1")
+	time.Sleep(20 * time.Millisecond)
+	get("/geo/zip?ip=3000::1")
+	if got := lookups.Load(); got != 2 {
+		t.Errorf("looked the unfound IP up %d times, want it looked up again once expired", got)
+	}
+}
+
+func TestNegativeCacheDisabled(t *testing.T) {
+	setConfig(t, &negativeCacheTTL, 0)
+	purgeCache()
+	lookups := countLookups(t)
+
+	get("/geo/zip?ip=3000::1")
+	get("/geo/zip?ip=3000::1")
+	if got := lookups.Load(); got != 2 {
+		t.Errorf("looked the unfound IP up %d times with NEGATIVE_CACHE_TTL=0, want 2", got)
+	}
+}
+
+func TestCacheSwapDuringLookup(t *testing.T) {
+	setConfig(t, &lookupTimeout, 0)
+	purgeCache()
+
+	// The first lookup runs on the old database and finishes after the swap
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var lookups atomic.Int64
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		record, err := geoDb.City(ip)
+		if lookups.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		return record, err
+	})
+
+	done := make(chan string)
+	go func() { done <- get("/geo/country?ip=81.2.69.142").Body.String() }()
+	<-entered
+	useDatabase(t, openTestDatabase(t, "GeoIP2-City", time.Now(), testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "FR"},
+	}}))
+	close(release)
+
+	if body := <-done; !strings.Contains(body, `"country_code":"GB"`) {
+		t.Fatalf("got %s for the lookup on the old database, want GB", body)
+	}
+	if body := get("/geo/country?ip=81.2.69.142").Body.String(); !strings.Contains(body, `"country_code":"FR"`) {
+		t.Errorf("got %s after the swap, want FR from the new database rather than the old one's cached record", body)
+	}
+}
+
+func TestCacheSwapDuringUnfoundLookup(t *testing.T) {
+	setConfig(t, &lookupTimeout, 0)
+	setConfig(t, &negativeCacheTTL, time.Minute)
+	purgeCache()
+
+	// The old database doesn't have the IP, but the new one does
+	generation := currentCacheGeneration()
+	useDatabase(t, openTestDatabase(t, "GeoIP2-City", time.Now(), testNetwork{"3000::/16", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "FR"},
+	}}))
+	setCachedNotFound("3000::1", generation)
+
+	if w := get("/geo/country?ip=3000::1"); w.Code != 200 {
+		t.Errorf("got status %d after the swap, want the new database's record rather than a cached miss", w.Code)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How many seconds clients and shared caches may reuse a geo response,
+// 0 marks them as no-store
+var cacheControlMaxAge = envInt("CACHE_CONTROL_MAX_AGE", 3600)
+
+// Returns the Cache-Control value for geo responses. Responses only the
+// caller should see are private: looking up the caller's own address (no
+// `ip`) or a `host` whose addresses can change, and anything behind an
+// API key, which a shared cache would otherwise hand to callers without
+// one.
+func geoCacheControl(c *gin.Context) string {
+	if cacheControlMaxAge <= 0 {
+		return "no-store"
+	}
+
+	_, hasIP := c.GetQuery("ip")
+	_, hasHost := c.GetQuery("host")
+	if !hasIP || hasHost || apiKey != "" {
+		return "private, max-age=" + strconv.Itoa(cacheControlMaxAge)
+	}
+	return "public, max-age=" + strconv.Itoa(cacheControlMaxAge)
+}
+
+// Lets clients and caching proxies reuse geo lookups, which rarely change
+// between database updates. Only GET responses are cacheable, and errors
+// such as timeouts are sent as no-store.
+func cacheControlMiddleware(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.Header("Cache-Control", "no-store")
+		c.Next()
+		return
+	}
+
+	c.Header("Cache-Control", geoCacheControl(c))
+	// The representation (JSON, XML, or GeoJSON) can be negotiated with
+	// the Accept header
+	c.Writer.Header().Add("Vary", "Accept")
+	c.Writer = &cacheControlWriter{ResponseWriter: c.Writer}
+	c.Next()
+}
+
+// Replaces the Cache-Control header with no-store when the response isn't
+// a success, a 304, or a 404 (which only changes with the database)
+type cacheControlWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *cacheControlWriter) WriteHeader(code int) {
+	if code >= http.StatusMultipleChoices && code != http.StatusNotModified && code != http.StatusNotFound {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Marks a response as never cacheable, for the probes and metrics
+func noStore(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Next()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheControl(t *testing.T) {
+	setConfig(t, &cacheControlMaxAge, 600)
+	stubHosts(t, "81.2.69.142")
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"ip", "/geo/zip?ip=81.2.69.142", "public, max-age=600"},
+		{"not found", "/geo/zip?ip=3000::1", "public, max-age=600"},
+		{"own address", "/geo/zip", "private, max-age=600"},
+		{"host", "/geo/zip?host=example.com", "private, max-age=600"},
+		{"error", "/geo/zip?ip=bogus", "no-store"},
+		{"rejected", "/geo/zip?ip=10.0.0.1", "no-store"},
+		{"probe", "/healthz", "no-store"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.RemoteAddr = "216.160.83.56:1234"
+			if got := serveRequest(req).Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("got Cache-Control %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheControlAPIKey(t *testing.T) {
+	setConfig(t, &cacheControlMaxAge, 600)
+	setConfig(t, &apiKey, "secret")
+
+	req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+	req.Header.Set("X-API-Key", "secret")
+	if got := serveRequest(req).Header().Get("Cache-Control"); got != "private, max-age=600" {
+		t.Errorf("got Cache-Control %q behind an API key, want it private", got)
+	}
+}
+
+func TestCacheControlNoStore(t *testing.T) {
+	setConfig(t, &cacheControlMaxAge, 0)
+
+	if got := get("/geo/zip?ip=81.2.69.142").Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("got Cache-Control %q with CACHE_CONTROL_MAX_AGE=0, want no-store", got)
+	}
+}
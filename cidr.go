@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Shortest prefixes of a CIDR accepted in the `ip` parameter. The first
+// address of a larger network says little about the rest of it.
+var minCIDRPrefixV4 = envInt("CIDR_MIN_PREFIX_V4", 16)
+var minCIDRPrefixV6 = envInt("CIDR_MIN_PREFIX_V6", 48)
+
+// Context key the network given in the `ip` parameter is stored under
+const lookupNetworkKey = "lookup_network"
+
+// Parses a CIDR given in the `ip` parameter, returning its base address to
+// look up in its place. The network is stored on the request so responses
+// can echo it. If it's invalid or too large, the request is ended with a
+// 400 and the second parameter returned is false.
+func parseCIDRParam(c *gin.Context, value string) (net.IP, bool) {
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "invalid network", "ip": value})
+		return nil, false
+	}
+
+	ones, bits := network.Mask.Size()
+	minPrefix := minCIDRPrefixV4
+	if bits == 8*net.IPv6len {
+		minPrefix = minCIDRPrefixV6
+	}
+	if ones < minPrefix {
+		c.AbortWithStatusJSON(400, gin.H{
+			"error": fmt.Sprintf("network is larger than the maximum of a /%d", minPrefix),
+			"ip":    value,
+		})
+		return nil, false
+	}
+
+	c.Set(lookupNetworkKey, network.String())
+	return network.IP, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestCIDRLookup(t *testing.T) {
+	var body struct {
+		GeoResponse
+		QueryNetwork string `json:"query_network"`
+	}
+	decodeResponse(t, get("/geo?ip=81.2.69.0/24"), 200, &body)
+
+	if body.QueryNetwork != "81.2.69.0/24" {
+		t.Errorf("got query_network %q, want 81.2.69.0/24 echoed", body.QueryNetwork)
+	}
+	if body.City != "Norwich" || body.Location.Latitude != 52.6259 || body.Location.Longitude != 1.3032 {
+		t.Errorf("got %+v, want the location of Norwich", body.GeoResponse)
+	}
+
+	// The network is normalized to its base address
+	decodeResponse(t, get("/geo?ip=81.2.69.142/24"), 200, &body)
+	if body.QueryNetwork != "81.2.69.0/24" {
+		t.Errorf("got query_network %q for a host address, want 81.2.69.0/24", body.QueryNetwork)
+	}
+}
+
+func TestCIDRLookupTooLarge(t *testing.T) {
+	setConfig(t, &minCIDRPrefixV4, 24)
+	setConfig(t, &minCIDRPrefixV6, 48)
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/geo?ip=81.2.0.0/16", `{"error":"network is larger than the maximum of a /24","ip":"81.2.0.0/16"}`},
+		{"/geo?ip=2001:218::/32", `{"error":"network is larger than the maximum of a /48","ip":"2001:218::/32"}`},
+		{"/geo?ip=81.2.69.0/33", `{"error":"invalid network","ip":"81.2.69.0/33"}`},
+	}
+
+	for _, tt := range tests {
+		if w := get(tt.target); w.Code != 400 || w.Body.String() != tt.want {
+			t.Errorf("got %d %s for %s, want 400 %s", w.Code, w.Body.String(), tt.target, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Cancelled on exit to stop the background goroutines (the self-test,
+// refresh, reload, and rate limiter eviction loops)
+var background, stopBackground = context.WithCancel(context.Background())
+
+var (
+	cleanupMu   sync.Mutex
+	cleanups    []func()
+	cleanupOnce sync.Once
+)
+
+// Registers a function to run on exit, such as closing a reader
+func onCleanup(fn func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanups = append(cleanups, fn)
+}
+
+// Stops the background goroutines and runs the registered cleanups in
+// reverse order, like defers, so resources are released after whatever
+// was set up on top of them. Only the first call does anything, so it's
+// safe to call from both the end of main and `fatal`.
+func cleanup() {
+	cleanupOnce.Do(func() {
+		stopBackground()
+
+		cleanupMu.Lock()
+		defer cleanupMu.Unlock()
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	})
+}
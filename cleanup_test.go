@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestCleanup(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	setConfig(t, &background, ctx)
+	setConfig(t, &stopBackground, stop)
+	setConfig(t, &cleanups, nil)
+	cleanupOnce = sync.Once{}
+	t.Cleanup(func() { cleanupOnce = sync.Once{} })
+
+	var ran []string
+	for _, name := range []string{"city", "asn", "cache"} {
+		onCleanup(func() { ran = append(ran, name) })
+	}
+
+	cleanup()
+	cleanup()
+
+	if want := []string{"cache", "asn", "city"}; !slices.Equal(ran, want) {
+		t.Errorf("ran cleanups %v, want each once in reverse order %v", ran, want)
+	}
+	if background.Err() == nil {
+		t.Error("the background goroutines weren't stopped")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Networks of the reverse proxies/load balancers in front of the service.
+// Forwarded headers are only honored from peers inside one of them.
+var trustedProxies []*net.IPNet
+
+// Whether peers connecting over UNIX_SOCKET are trusted to forward the
+// client's address. They have no IP to check against TRUSTED_PROXIES, and
+// only local processes allowed by the socket's permissions can connect.
+var trustUnixSocket = envBool("TRUST_UNIX_SOCKET", true)
+
+// Warns the first time a request carries forwarded headers while no
+// trusted proxies are configured
+var warnUntrustedForward sync.Once
+
+// Parses a comma-separated list of CIDRs or bare IP addresses into
+// networks. Bare addresses are treated as a single-host network.
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// Configures gin with the same trusted proxies, so its own c.ClientIP()
+// used by middleware agrees with `clientIP`. With none configured,
+// forwarded headers are never trusted.
+func setTrustedProxies(router *gin.Engine) error {
+	cidrs := make([]string, 0, len(trustedProxies))
+	for _, network := range trustedProxies {
+		cidrs = append(cidrs, network.String())
+	}
+	if len(cidrs) == 0 {
+		return router.SetTrustedProxies(nil)
+	}
+	return router.SetTrustedProxies(cidrs)
+}
+
+// Whether the IP belongs to one of the trusted proxy networks
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the address of the client that made the request. The precedence is:
+//
+//  1. X-Forwarded-For, walking from the right-most (closest) hop and
+//     skipping trusted proxies, so a client can't spoof its address by
+//     prepending entries to the header
+//  2. X-Real-IP
+//  3. The address of the immediate peer
+//
+// The forwarded headers are only consulted when the immediate peer is
+// itself a trusted proxy, or connected over UNIX_SOCKET while
+// TRUST_UNIX_SOCKET is set; otherwise the peer address is always used.
+// Returns nil when there's no address, e.g. for a Unix socket peer that
+// didn't forward one.
+func clientIP(c *gin.Context) net.IP {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	trusted := peer != nil && isTrustedProxy(peer)
+	if peer == nil && unixSocket != "" && trustUnixSocket {
+		trusted = true
+	}
+	if !trusted {
+		if len(trustedProxies) == 0 && (c.GetHeader("X-Forwarded-For") != "" || c.GetHeader("X-Real-IP") != "") {
+			warnUntrustedForward.Do(func() {
+				slog.Warn("ignoring X-Forwarded-For and X-Real-IP since TRUSTED_PROXIES isn't set; set it if the service is behind a proxy")
+			})
+		}
+		return peer
+	}
+
+	var hops []string
+	for _, header := range c.Request.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// A malformed hop means the rest of the chain can't be trusted
+			break
+		}
+		if i == 0 || !isTrustedProxy(ip) {
+			return ip
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(c.GetHeader("X-Real-IP"))); ip != nil {
+		return ip
+	}
+
+	return peer
+}
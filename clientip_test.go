@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gets the client IP of a request from the peer with the headers
+func testClientIP(remoteAddr string, headers map[string]string) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/geo", nil)
+	c.Request.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		c.Request.Header.Set(name, value)
+	}
+	return clientIP(c).String()
+}
+
+func TestClientIPForwardedHeaders(t *testing.T) {
+	setConfig(t, &trustedProxies, mustParseCIDRs("10.0.0.0/8"))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"no headers", "81.2.69.142:1234", nil, "81.2.69.142"},
+		{"spoofed from an untrusted peer", "81.2.69.142:1234", map[string]string{"X-Forwarded-For": "216.160.83.56"}, "81.2.69.142"},
+		{"spoofed real ip from an untrusted peer", "81.2.69.142:1234", map[string]string{"X-Real-IP": "216.160.83.56"}, "81.2.69.142"},
+		{"forwarded by a trusted proxy", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "216.160.83.56"}, "216.160.83.56"},
+		{"prepended hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 216.160.83.56"}, "216.160.83.56"},
+		{"chain of trusted proxies", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "216.160.83.56, 10.0.0.2"}, "216.160.83.56"},
+		{"malformed hop", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "216.160.83.56, bogus"}, "10.0.0.1"},
+		{"real ip from a trusted proxy", "10.0.0.1:1234", map[string]string{"X-Real-IP": "216.160.83.56"}, "216.160.83.56"},
+		{"forwarded for before real ip", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "216.160.83.56", "X-Real-IP": "89.160.20.112"}, "216.160.83.56"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testClientIP(tt.remoteAddr, tt.headers); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPSpoofedLookup(t *testing.T) {
+	setConfig(t, &trustedProxies, nil)
+
+	// The peer's own address is looked up, which is a reserved one
+	req := httptest.NewRequest("GET", "/geo/country", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Forwarded-For", "81.2.69.142")
+	if w := serveRequest(req); w.Code != 422 {
+		t.Errorf("got status %d, want 422 for the peer's reserved address", w.Code)
+	}
+}
+
+func TestClientIPUnixSocket(t *testing.T) {
+	setConfig(t, &trustedProxies, nil)
+	setConfig(t, &unixSocket, "/run/geoip.sock")
+
+	// Peers on a Unix socket have no address of their own
+	forwarded := map[string]string{"X-Forwarded-For": "216.160.83.56"}
+	if got := testClientIP("@", forwarded); got != "216.160.83.56" {
+		t.Errorf("got %s from a trusted socket peer, want the forwarded address", got)
+	}
+	if got := testClientIP("@", nil); got != "<nil>" {
+		t.Errorf("got %s from a socket peer that didn't forward an address, want none", got)
+	}
+
+	setConfig(t, &trustUnixSocket, false)
+	if got := testClientIP("@", forwarded); got != "<nil>" {
+		t.Errorf("got %s from an untrusted socket peer, want none", got)
+	}
+}
+
+func TestGinClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    []string
+		remoteAddr string
+		want       string
+	}{
+		{"no trusted proxies", nil, "10.0.0.1:1234", "10.0.0.1"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "81.2.69.142:1234", "81.2.69.142"},
+		{"trusted peer", []string{"10.0.0.0/8"}, "10.0.0.1:1234", "216.160.83.56"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &trustedProxies, mustParseCIDRs(tt.proxies...))
+
+			router := gin.New()
+			if err := setTrustedProxies(router); err != nil {
+				t.Fatal(err)
+			}
+			router.GET("/", func(c *gin.Context) { c.String(200, c.ClientIP()) })
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "216.160.83.56")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Body.String() != tt.want {
+				t.Errorf("got ClientIP %s, want %s", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWarnsWithoutTrustedProxies(t *testing.T) {
+	setConfig(t, &trustedProxies, nil)
+	warnUntrustedForward = sync.Once{}
+	t.Cleanup(func() { warnUntrustedForward = sync.Once{} })
+	logs := captureLogs(t, nil)
+
+	headers := map[string]string{"X-Forwarded-For": "216.160.83.56"}
+	testClientIP("81.2.69.142:1234", headers)
+	testClientIP("81.2.69.142:1234", headers)
+
+	if lines := logLines(t, logs, "ignoring X-Forwarded-For and X-Real-IP since TRUSTED_PROXIES isn't set; set it if the service is behind a proxy"); len(lines) != 1 {
+		t.Errorf("got %d warnings for forwarded headers, want 1", len(lines))
+	}
+}
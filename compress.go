@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-contrib/gzip"
+	"github.com/gin-gonic/gin"
+)
+
+// gzip compression level from 1 (fastest) to 9 (smallest), -1 for the
+// library default, or 0 to disable compression
+var gzipLevel = envInt("GZIP_LEVEL", gzip.DefaultCompression)
+
+// Responses smaller than this many bytes aren't worth compressing
+var gzipMinLength = envInt("GZIP_MIN_LENGTH", 1024)
+
+// Creates the gzip middleware, or nil when compression is disabled.
+// Probes are never compressed, and /metrics is left to the Prometheus
+// handler which negotiates its own compression.
+func compressionMiddleware() gin.HandlerFunc {
+	if gzipLevel == 0 {
+		return nil
+	}
+
+	if gzipLevel < gzip.HuffmanOnly || gzipLevel > gzip.BestCompression {
+		fatal(fmt.Sprintf("GZIP_LEVEL must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression), "value", gzipLevel)
+	}
+	if gzipMinLength < 0 {
+		fatal("GZIP_MIN_LENGTH must not be negative", "value", gzipMinLength)
+	}
+
+	return gzip.Gzip(gzipLevel,
+		gzip.WithMinLength(gzipMinLength),
+		gzip.WithExcludedPaths([]string{"/healthz", "/readyz", "/metrics"}),
+	)
+}
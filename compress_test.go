@@ -0,0 +1,64 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Posts a batch large enough to be compressed
+func postLargeBatch(acceptEncoding string) *httptest.ResponseRecorder {
+	ips := strings.Repeat(`"81.2.69.142", `, 50) + `"216.160.83.56"`
+	req := httptest.NewRequest("POST", "/geo/batch", strings.NewReader(`{"ips": [`+ips+`]}`))
+	req.Header.Set("Content-Type", "application/json")
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return serveRequest(req)
+}
+
+func TestCompressionLargeBatch(t *testing.T) {
+	w := postLargeBatch("gzip")
+	if w.Code != 200 {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+
+	reader, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []BatchResult
+	if err := json.NewDecoder(reader).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 51 {
+		t.Errorf("got %d results, want 51", len(results))
+	}
+}
+
+func TestCompressionNotAccepted(t *testing.T) {
+	if got := postLargeBatch("").Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q without Accept-Encoding", got)
+	}
+}
+
+func TestCompressionSmallResponse(t *testing.T) {
+	req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	if got := serveRequest(req).Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q for a response below GZIP_MIN_LENGTH", got)
+	}
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	setConfig(t, &gzipLevel, 0)
+
+	if got := postLargeBatch("gzip").Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q with GZIP_LEVEL=0", got)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Maximum number of geo requests handled at once, 0 means unlimited
+var maxConcurrent = envInt("MAX_CONCURRENT", 0)
+
+// How long a request waits for a slot once MAX_CONCURRENT is reached
+// before it's rejected, 0 rejects it straight away
+var maxConcurrentWait = envDuration("MAX_CONCURRENT_WAIT", 0)
+
+// Caps the number of geo requests in flight so a traffic spike can't
+// exhaust CPU or memory. Requests over the cap get a 503 once they've
+// waited MAX_CONCURRENT_WAIT for a slot. Returns nil when MAX_CONCURRENT
+// isn't set.
+func concurrencyMiddleware() gin.HandlerFunc {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+
+	slots := make(chan struct{}, maxConcurrent)
+	return func(c *gin.Context) {
+		if !acquireSlot(c, slots) {
+			c.AbortWithStatusJSON(503, gin.H{"error": "too many concurrent requests"})
+			return
+		}
+		defer func() { <-slots }()
+
+		c.Next()
+	}
+}
+
+// Takes a slot from the semaphore, waiting up to MAX_CONCURRENT_WAIT for
+// one to free up. Returns false if none did or the request was cancelled.
+func acquireSlot(c *gin.Context, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if maxConcurrentWait <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(maxConcurrentWait)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	setConfig(t, &maxConcurrent, 2)
+	setConfig(t, &maxConcurrentWait, 0)
+
+	// Lookups are held until the requests over the cap have been made
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+		return geoDb.City(ip)
+	})
+	purgeCache()
+
+	router := newRouter()
+	request := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil))
+		return w.Code
+	}
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 2)
+	for i := range statuses {
+		wg.Go(func() { statuses[i] = request() })
+		<-entered
+	}
+
+	for range 3 {
+		if status := request(); status != 503 {
+			t.Errorf("got status %d over the cap, want 503", status)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+	for _, status := range statuses {
+		if status != 200 {
+			t.Errorf("got status %d within the cap, want 200", status)
+		}
+	}
+
+	// The slots are freed once the requests finish
+	if status := request(); status != 200 {
+		t.Errorf("got status %d once the slots were freed, want 200", status)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reads a string environment variable, falling back to the default when
+// unset
+func envString(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Reads an integer environment variable, falling back to the default
+// when unset. Exits if the value isn't a valid integer.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		fatal(name+" must be an integer", "value", value)
+	}
+	return parsed
+}
+
+// Reads a decimal environment variable, falling back to the default when
+// unset. Exits if the value isn't a valid number.
+func envFloat(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		fatal(name+" must be a number", "value", value)
+	}
+	return parsed
+}
+
+// Reads a duration environment variable (e.g. "10s"), falling back to the
+// default when unset. Exits if the value isn't a valid duration.
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		fatal(name+" must be a duration (e.g. \"10s\")", "value", value)
+	}
+	return parsed
+}
+
+// Reads a boolean environment variable (e.g. "true" or "1"), falling back
+// to the default when unset. Exits if the value isn't a valid boolean.
+func envBool(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		fatal(name+" must be true or false", "value", value)
+	}
+	return parsed
+}
+
+// Reads a comma-separated environment variable, falling back to the
+// default when unset. Blank entries are dropped.
+func envList(name string, def []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// Origins allowed to call the service from a browser, "*" allows any.
+// CORS is disabled when none are configured.
+var allowedOrigins = envList("ALLOWED_ORIGINS", nil)
+
+var corsAllowedMethods = envList("CORS_ALLOWED_METHODS", []string{"GET", "POST", "HEAD", "OPTIONS"})
+var corsAllowedHeaders = envList("CORS_ALLOWED_HEADERS", []string{"Origin", "Content-Type", "Accept", "X-API-Key"})
+var corsAllowCredentials = envBool("CORS_ALLOW_CREDENTIALS", false)
+
+// Creates the CORS middleware, or nil when no origins are allowed. It's
+// installed on the router itself rather than the geo group so preflight
+// OPTIONS requests, which have no route of their own, are answered.
+func corsMiddleware() gin.HandlerFunc {
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+
+	config := cors.Config{
+		AllowMethods:     corsAllowedMethods,
+		AllowHeaders:     corsAllowedHeaders,
+		AllowCredentials: corsAllowCredentials,
+		ExposeHeaders:    []string{requestIDHeader},
+		MaxAge:           12 * time.Hour,
+	}
+	if len(allowedOrigins) == 1 && allowedOrigins[0] == "*" {
+		config.AllowAllOrigins = true
+	} else {
+		config.AllowOrigins = allowedOrigins
+	}
+
+	if err := config.Validate(); err != nil {
+		fatal("invalid CORS configuration", "error", err)
+	}
+	return cors.New(config)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Serves a request for the zip code from the origin
+func getFromOrigin(origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+	req.Header.Set("Origin", origin)
+	return serveRequest(req)
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	setConfig(t, &allowedOrigins, []string{"https://app.example.org"})
+
+	w := getFromOrigin("https://app.example.org")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.org" {
+		t.Errorf("got Access-Control-Allow-Origin %q for an allowed origin", got)
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	setConfig(t, &allowedOrigins, []string{"https://app.example.org"})
+
+	w := getFromOrigin("https://evil.example")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("got Access-Control-Allow-Origin %q for a disallowed origin", got)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	setConfig(t, &allowedOrigins, []string{"*"})
+
+	req := httptest.NewRequest("OPTIONS", "/geo/batch", nil)
+	req.Header.Set("Origin", "https://app.example.org")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := serveRequest(req)
+
+	if w.Code != 204 {
+		t.Errorf("got status %d for a preflight, want 204", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q with any origin allowed", got)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	setConfig(t, &allowedOrigins, nil)
+
+	if got := getFromOrigin("https://app.example.org").Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("got Access-Control-Allow-Origin %q with CORS disabled", got)
+	}
+}
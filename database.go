@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var errNoDatabase = errors.New("geoip database is not loaded")
+
+// Number of readers of the database to spread lookups across. Each has its
+// own lock, so a pool reduces contention at very high request rates.
+var readerPoolSize = envInt("READER_POOL_SIZE", 1)
+
+// database is a GeoIP database that can be swapped out at runtime without
+// interrupting lookups. It reads the MaxMind DB directly, decoding into the
+// geoip2 record types, so the network a lookup matched is available too.
+// Lookups are spread round-robin across a pool of readers of the same
+// database.
+type database struct {
+	shards []*databaseShard
+	next   atomic.Uint64
+}
+
+// databaseShard is one reader of the pool along with its lock, which is
+// only held while the reader is swapped or a reference to it is taken
+type databaseShard struct {
+	mu     sync.RWMutex
+	reader *databaseReader
+}
+
+// databaseReader is a reader counting the references to it: one held by
+// its shard while it's current, and one by each lookup using it. A reader
+// that's swapped out is closed once the last lookup releases it, so a
+// reload neither waits for in-flight lookups nor closes the reader under
+// them.
+type databaseReader struct {
+	reader *maxminddb.Reader
+	refs   atomic.Int64
+}
+
+// Wraps a newly opened reader, holding the reference for its shard
+func newDatabaseReader(reader *maxminddb.Reader) *databaseReader {
+	r := &databaseReader{reader: reader}
+	r.refs.Store(1)
+	return r
+}
+
+// Drops a reference, closing the reader if it was the last one
+func (r *databaseReader) release() {
+	if r.refs.Add(-1) == 0 {
+		r.reader.Close()
+	}
+}
+
+// Creates a database with a pool of the given number of readers, none of
+// them loaded until the first `Swap`
+func newDatabase(size int) *database {
+	d := &database{}
+	for range max(size, 1) {
+		d.shards = append(d.shards, &databaseShard{})
+	}
+	return d
+}
+
+// Picks the reader for the next lookup and takes a reference to it. The
+// caller must call the returned release func once done with the reader,
+// which is nil when no database is loaded.
+func (d *database) acquire() (*maxminddb.Reader, func()) {
+	shard := d.shards[int(d.next.Add(1)%uint64(len(d.shards)))]
+	shard.mu.RLock()
+	current := shard.reader
+	if current != nil {
+		current.refs.Add(1)
+	}
+	shard.mu.RUnlock()
+
+	if current == nil {
+		return nil, func() {}
+	}
+	return current.reader, current.release
+}
+
+// cityRecord is a city record along with what else the lookup of it found:
+// the network of the database the IP matched, and for GeoIP2 Enterprise
+// databases the city and postal confidence scores, which are nil for other
+// databases
+type cityRecord struct {
+	geoRecord
+	network          *net.IPNet
+	cityConfidence   *uint8
+	postalConfidence *uint8
+}
+
+// The city record embedded in a `cityRecord`, aliased so its fields,
+// including `City`, are promoted without the embedded field shadowing them
+type geoRecord = geoip2.City
+
+// The confidence scores of a GeoIP2 Enterprise record
+type confidenceRecord struct {
+	City struct {
+		Confidence uint8 `maxminddb:"confidence"`
+	} `maxminddb:"city"`
+	Postal struct {
+		Confidence uint8 `maxminddb:"confidence"`
+	} `maxminddb:"postal"`
+}
+
+// Looks up the city record for an IP address with the current reader,
+// along with the network it matched and any confidence scores. For an IP
+// that isn't in the database, the network is the range of addresses that
+// aren't either.
+func (d *database) City(ip net.IP) (*cityRecord, error) {
+	reader, release := d.acquire()
+	defer release()
+
+	if reader == nil {
+		return nil, errNoDatabase
+	}
+
+	var record cityRecord
+	network, ok, err := reader.LookupNetwork(ip, &record.geoRecord)
+	if err != nil {
+		return &record, err
+	}
+	record.network = network
+
+	if ok && strings.Contains(reader.Metadata.DatabaseType, "Enterprise") {
+		offset, err := reader.LookupOffset(ip)
+		if err != nil {
+			return &record, err
+		}
+		var confidence confidenceRecord
+		if err := reader.Decode(offset, &confidence); err != nil {
+			return &record, err
+		}
+		record.cityConfidence = &confidence.City.Confidence
+		record.postalConfidence = &confidence.Postal.Confidence
+	}
+	return &record, nil
+}
+
+// Gets the metadata of the current reader. The second parameter returned
+// is false when no database is loaded.
+func (d *database) Metadata() (maxminddb.Metadata, bool) {
+	reader, release := d.acquire()
+	defer release()
+
+	if reader == nil {
+		return maxminddb.Metadata{}, false
+	}
+	return reader.Metadata, true
+}
+
+// Replaces the readers of the pool, one per reader, or unloads the
+// database when readers is nil. Lookups started after the swap use the new
+// readers straight away, while each old reader is closed once every
+// in-flight lookup using it has finished.
+func (d *database) Swap(readers []*maxminddb.Reader) {
+	for i, shard := range d.shards {
+		var reader *databaseReader
+		if readers != nil {
+			reader = newDatabaseReader(readers[i])
+		}
+
+		shard.mu.Lock()
+		old := shard.reader
+		shard.reader = reader
+		shard.mu.Unlock()
+
+		if old != nil {
+			old.release()
+		}
+	}
+}
+
+// Closes the current readers
+func (d *database) Close() error {
+	d.Swap(nil)
+	return nil
+}
+
+// Opens a reader for each slot of the pool with open and checks the
+// database's metadata. If either fails, the readers already opened are
+// closed.
+func openReaders(open func() (*maxminddb.Reader, error)) ([]*maxminddb.Reader, error) {
+	readers := make([]*maxminddb.Reader, 0, len(geoDb.shards))
+	closeAll := func() {
+		for _, opened := range readers {
+			opened.Close()
+		}
+	}
+
+	for range len(geoDb.shards) {
+		reader, err := open()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+
+	if err := validateMetadata(readers[0].Metadata); err != nil {
+		closeAll()
+		return nil, err
+	}
+	return readers, nil
+}
+
+// Opens a reader of the database bundled into the binary
+func openEmbeddedDatabase() (*maxminddb.Reader, error) {
+	return maxminddb.FromBytes(embeddedDb)
+}
+
+// Checks the metadata of a database looks sane and that it can answer
+// city lookups. A truncated or corrupt file can still open, but then has
+// empty metadata, and a database of another type (e.g. ASN) would find
+// nothing for every IP.
+func validateMetadata(metadata maxminddb.Metadata) error {
+	if metadata.DatabaseType == "" {
+		return errors.New("database has no type in its metadata, the file may be truncated or corrupt")
+	}
+
+	built := time.Unix(int64(metadata.BuildEpoch), 0).UTC()
+	if built.Year() < 2000 || built.After(time.Now().Add(24*time.Hour)) {
+		return fmt.Errorf("database has an implausible build time of %s, the file may be corrupt", built.Format(time.RFC3339))
+	}
+
+	if !strings.Contains(metadata.DatabaseType, "City") &&
+		!strings.Contains(metadata.DatabaseType, "Country") &&
+		!strings.Contains(metadata.DatabaseType, "Enterprise") {
+		return fmt.Errorf("database is a %s database, which can't be used for city lookups; use a City, Country, or Enterprise database", metadata.DatabaseType)
+	}
+	return nil
+}
+
+// Resolves the path of the database from the GEO_FILE value, defaulting to
+// `defaultGeoFile`. Fails with an explanation of how to set GEO_FILE when
+// there's no database at the path.
+func resolveGeoFile(value string) (string, error) {
+	path := value
+	if path == "" {
+		path = defaultGeoFile
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		if value == "" {
+			return path, fmt.Errorf("no Maxmind GeoIP database at the default path %s (%w); set GEO_FILE to the location of your database, e.g. GEO_FILE=./GeoLite2-City.mmdb", path, err)
+		}
+		return path, fmt.Errorf("no Maxmind GeoIP database at GEO_FILE=%s (%w); set GEO_FILE to the location of your database", path, err)
+	}
+	return path, nil
+}
+
+// Serializes reloads, which can be triggered at once by SIGHUP, the
+// watcher, and refreshes, so the readers of the pool are always swapped
+// together and for the latest file
+var reloadMu sync.Mutex
+
+// Reopens GEO_FILE and swaps it in for the current reader. On failure the
+// current reader is kept so the service continues serving lookups. An
+// embedded database can't change, so there's nothing to reload.
+func reloadDatabase() {
+	if dbEmbedded {
+		slog.Info("the database is embedded, skipping reload")
+		return
+	}
+
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	readers, err := openReaders(func() (*maxminddb.Reader, error) { return maxminddb.Open(geoFile) })
+	if err != nil {
+		slog.Error("failed to reload database, keeping the current one", "file", geoFile, "error", err)
+		return
+	}
+
+	geoDb.Swap(readers)
+	purgeCache()
+	dbSelfTest.run()
+	slog.Info("reloaded database", "file", geoFile)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Opens the given number of readers of the repository's database
+func openTestReaders(t testing.TB, count int) []*maxminddb.Reader {
+	t.Helper()
+
+	readers := make([]*maxminddb.Reader, 0, count)
+	for range count {
+		reader, err := openTestGeoFile()
+		if err != nil {
+			t.Fatalf("failed to open the test database: %v", err)
+		}
+		readers = append(readers, reader)
+	}
+	return readers
+}
+
+func TestDatabaseSwapDuringLookups(t *testing.T) {
+	db := newDatabase(1)
+	db.Swap(openTestReaders(t, 1))
+	defer db.Close()
+
+	ip := net.ParseIP("81.2.69.142")
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				record, err := db.City(ip)
+				if err != nil || record.Country.IsoCode != "GB" {
+					t.Errorf("lookup during a swap got %v, %v", record, err)
+					return
+				}
+			}
+		})
+	}
+
+	for range 50 {
+		db.Swap(openTestReaders(t, 1))
+	}
+	close(stop)
+	wg.Wait()
+
+	// Lookups after the last swap use its reader
+	reader, err := maxminddb.FromBytes(buildTestCityDatabase(t, "FR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Swap([]*maxminddb.Reader{reader})
+	if record, err := db.City(ip); err != nil || record.Country.IsoCode != "FR" {
+		t.Errorf("got %v, %v after the last swap, want FR", record, err)
+	}
+}
+
+func TestDatabaseSwap(t *testing.T) {
+	db := newDatabase(1)
+	defer db.Close()
+
+	ip := net.ParseIP("81.2.69.142")
+	if _, err := db.City(ip); !errors.Is(err, errNoDatabase) {
+		t.Fatalf("got %v before the first swap, want errNoDatabase", err)
+	}
+
+	db.Swap(openTestReaders(t, 1))
+	if record, err := db.City(ip); err != nil || record.Country.IsoCode != "GB" {
+		t.Fatalf("got %v, %v from the repository's database", record, err)
+	}
+
+	// Lookups use the new reader as soon as it's swapped in
+	reader, err := maxminddb.FromBytes(buildTestDatabase(t, "GeoIP2-City", time.Now(),
+		testNetwork{"81.2.69.0/24", map[string]interface{}{"country": map[string]interface{}{"iso_code": "FR"}}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Swap([]*maxminddb.Reader{reader})
+	if record, err := db.City(ip); err != nil || record.Country.IsoCode != "FR" {
+		t.Fatalf("got %v, %v from the swapped in database", record, err)
+	}
+
+	db.Swap(nil)
+	if _, err := db.City(ip); !errors.Is(err, errNoDatabase) {
+		t.Errorf("got %v once unloaded, want errNoDatabase", err)
+	}
+}
+
+func TestDatabasePoolSizes(t *testing.T) {
+	single := newDatabase(1)
+	single.Swap(openTestReaders(t, 1))
+	defer single.Close()
+	pool := newDatabase(4)
+	pool.Swap(openTestReaders(t, 4))
+	defer pool.Close()
+
+	for _, value := range []string{"81.2.69.142", "216.160.83.56", "89.160.20.112", "1.0.0.1", "2001:218::1", "3000::1"} {
+		ip := net.ParseIP(value)
+		want, err := single.City(ip)
+		if err != nil {
+			t.Fatalf("looking up %s: %v", value, err)
+		}
+
+		// Enough lookups for every reader of the pool to serve one
+		for range 2 * len(pool.shards) {
+			got, err := pool.City(ip)
+			if err != nil {
+				t.Fatalf("looking up %s in the pool: %v", value, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v for %s from the pool, want %+v", got, value, want)
+			}
+		}
+	}
+}
+
+func BenchmarkDatabaseCity(b *testing.B) {
+	ip := net.ParseIP("81.2.69.142")
+	for _, size := range []int{1, 4} {
+		b.Run(fmt.Sprintf("pool=%d", size), func(b *testing.B) {
+			db := newDatabase(size)
+			db.Swap(openTestReaders(b, size))
+			defer db.Close()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := db.City(ip); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestResolveGeoFile(t *testing.T) {
+	path, err := resolveGeoFile("")
+	if path != defaultGeoFile {
+		t.Errorf("got path %s without GEO_FILE, want the default %s", path, defaultGeoFile)
+	}
+	if err == nil || !strings.Contains(err.Error(), "GEO_FILE") {
+		t.Errorf("got error %v for a missing default database, want it to mention GEO_FILE", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.mmdb")
+	if _, err := resolveGeoFile(missing); err == nil || !strings.Contains(err.Error(), "GEO_FILE="+missing) {
+		t.Errorf("got error %v for a missing GEO_FILE, want it to name the path", err)
+	}
+
+	if path, err := resolveGeoFile(testGeoFile); err != nil || path != testGeoFile {
+		t.Errorf("got %s, %v for an existing GEO_FILE", path, err)
+	}
+}
+
+func TestOpenEmbeddedDatabase(t *testing.T) {
+	setConfig(t, &embeddedDb, buildTestCityDatabase(t, "FR"))
+
+	readers, err := openReaders(openEmbeddedDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useDatabase(t, readers...)
+
+	if record, err := geoDb.City(net.ParseIP("81.2.69.142")); err != nil || record.Country.IsoCode != "FR" {
+		t.Errorf("got %v, %v from the embedded database", record, err)
+	}
+}
+
+func TestOpenReadersTruncated(t *testing.T) {
+	data, err := os.ReadFile(testGeoFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "truncated.mmdb")
+	if err := os.WriteFile(path, data[:len(data)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	readers, err := openReaders(func() (*maxminddb.Reader, error) { return maxminddb.Open(path) })
+	if err == nil {
+		t.Fatalf("opened %d readers of a truncated database", len(readers))
+	}
+	if !strings.Contains(err.Error(), "invalid MaxMind DB file") {
+		t.Errorf("got error %q for a truncated database, want it to say the file is invalid", err)
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		databaseType string
+		built        time.Time
+		want         string
+	}{
+		{"valid", "GeoIP2-City", time.Now(), ""},
+		{"country", "GeoLite2-Country", time.Now(), ""},
+		{"no type", "", time.Now(), "database has no type in its metadata, the file may be truncated or corrupt"},
+		{"no build time", "GeoIP2-City", time.Unix(0, 0), "database has an implausible build time of 1970-01-01T00:00:00Z, the file may be corrupt"},
+		{"built in the future", "GeoIP2-City", time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC), "database has an implausible build time of 2200-01-01T00:00:00Z, the file may be corrupt"},
+		{"ASN", "GeoLite2-ASN", time.Now(), "database is a GeoLite2-ASN database, which can't be used for city lookups; use a City, Country, or Enterprise database"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildTestDatabase(t, tt.databaseType, tt.built, testNetwork{"81.2.69.0/24", map[string]interface{}{}})
+			readers, err := openReaders(func() (*maxminddb.Reader, error) { return maxminddb.FromBytes(data) })
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got error %v for a valid database", err)
+				}
+				for _, reader := range readers {
+					reader.Close()
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadDuringLookups(t *testing.T) {
+	// Reloads alternate between the repository's database and one with
+	// another zip code for the IP, ending on the latter
+	newFile := filepath.Join(t.TempDir(), "new.mmdb")
+	newData := buildTestDatabase(t, "GeoIP2-City", time.Now(), testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "GB"},
+		"postal":  map[string]interface{}{"code": "NR2"},
+	}})
+	if err := os.WriteFile(newFile, newData, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig(t, &geoFile, testGeoFile)
+	setConfig(t, &dbSelfTest, &selfTest{})
+	restoreDatabase(t)
+	purgeCache()
+	logs := captureLogs(t, nil)
+	router := newRouter()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil))
+				if w.Code != 200 || (w.Body.String() != `{"zip":"NR1"}` && w.Body.String() != `{"zip":"NR2"}`) {
+					t.Errorf("lookup during a reload got %d %s", w.Code, w.Body.String())
+					return
+				}
+			}
+		})
+	}
+
+	for i := range 20 {
+		geoFile = testGeoFile
+		if i%2 == 1 {
+			geoFile = newFile
+		}
+		reloadDatabase()
+	}
+	close(stop)
+	wg.Wait()
+
+	if reloads := logLines(t, logs, "reloaded database"); len(reloads) != 20 {
+		t.Errorf("reloaded the database %d times, want 20", len(reloads))
+	}
+
+	// Nothing looked up in an older database is still served
+	for range 3 {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil))
+		if w.Body.String() != `{"zip":"NR2"}` {
+			t.Fatalf("got %s after the last reload, want NR2 from the new database", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mean radius of the Earth in kilometers
+const earthRadiusKm = 6371.0
+
+// Computes the great-circle distance in kilometers between two lat/lon
+// points (in degrees) with the haversine formula
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Whether the record has coordinates
+func hasLocation(record *cityRecord) bool {
+	return record.Location.Latitude != 0 || record.Location.Longitude != 0
+}
+
+// Returns the distance in kilometers between the locations of the `from`
+// and `to` IP addresses. Both parameters are validated before responding
+// so that every problem is reported at once.
+func distanceHandler(c *gin.Context) {
+	params := []string{"from", "to"}
+	ips := map[string]net.IP{}
+	invalid := gin.H{}
+	for _, param := range params {
+		ip := net.ParseIP(c.Query(param))
+		if ip == nil {
+			invalid[param] = "invalid or missing ip"
+			continue
+		}
+		ips[param] = ip
+	}
+
+	if len(invalid) > 0 {
+		c.AbortWithStatusJSON(400, gin.H{"error": "invalid ip parameters", "params": invalid})
+		return
+	}
+
+	// Rejected like the `ip` of every other route, rather than reported as
+	// having no location
+	rejected := gin.H{}
+	for _, param := range params {
+		if rejectIP(normalizeIP(ips[param])) {
+			rejected[param] = ips[param].String()
+		}
+	}
+	if len(rejected) > 0 {
+		c.AbortWithStatusJSON(422, gin.H{"error": "private or reserved ip", "params": rejected})
+		return
+	}
+
+	records := map[string]*cityRecord{}
+	missing := gin.H{}
+	for _, param := range params {
+		record, err := lookupCity(c.Request.Context(), ips[param])
+		if errors.Is(err, context.DeadlineExceeded) {
+			c.AbortWithStatusJSON(504, gin.H{"error": "lookup timed out"})
+			return
+		}
+		if errors.Is(err, errNoDatabase) {
+			c.AbortWithStatusJSON(503, gin.H{"error": "database not loaded"})
+			return
+		}
+		if err != nil && !errors.Is(err, errNotFound) {
+			slog.Error("city lookup failed", "ip", ips[param].String(), "error", err)
+			c.AbortWithStatus(500)
+			return
+		}
+
+		if record == nil || !hasLocation(record) {
+			missing[param] = fmt.Sprintf("no location for %s", ips[param])
+			continue
+		}
+		records[param] = record
+	}
+
+	if len(missing) > 0 {
+		c.AbortWithStatusJSON(422, gin.H{"error": "ip has no location", "params": missing})
+		return
+	}
+
+	from, to := records["from"].Location, records["to"].Location
+	render(c, 200, gin.H{
+		"km": haversineKm(from.Latitude, from.Longitude, to.Latitude, to.Longitude),
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHaversineKm(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"London to Paris", 51.5074, -0.1278, 48.8566, 2.3522, 343.56},
+		{"New York to Los Angeles", 40.7128, -74.0060, 34.0522, -118.2437, 3935.75},
+		{"same point", 52.6259, 1.3032, 52.6259, 1.3032, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := haversineKm(tt.lat1, tt.lon1, tt.lat2, tt.lon2); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("got %.2f km, want %.2f km", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistanceHandler(t *testing.T) {
+	var body struct {
+		Km float64 `json:"km"`
+	}
+	decodeResponse(t, get("/geo/distance?from=81.2.69.142&to=216.160.83.56"), 200, &body)
+
+	// Norwich to Milton, WA
+	if math.Abs(body.Km-7658.95) > 0.01 {
+		t.Errorf("got %.2f km, want 7658.95 km", body.Km)
+	}
+}
+
+func TestDistanceHandlerInvalidIPs(t *testing.T) {
+	var body struct {
+		Params map[string]string `json:"params"`
+	}
+	decodeResponse(t, get("/geo/distance?from=bogus"), 400, &body)
+
+	// Both parameters are reported at once
+	if body.Params["from"] == "" || body.Params["to"] == "" {
+		t.Errorf("got params %v, want an error for both from and to", body.Params)
+	}
+}
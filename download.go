@@ -0,0 +1,241 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// HTTPS URL to download the database from on startup
+var geoURL string = os.Getenv("GEO_URL")
+
+// Expected SHA-256 of the file at GEO_URL, as downloaded (e.g. of the
+// .tar.gz archive rather than the database inside it)
+var geoSHA256 string = os.Getenv("GEO_SHA256")
+
+// Credentials for downloading GEO_URL, either basic auth or a bearer token
+var geoURLUser string = os.Getenv("GEO_URL_USER")
+var geoURLPassword string = os.Getenv("GEO_URL_PASSWORD")
+var geoURLToken string = os.Getenv("GEO_URL_TOKEN")
+
+// How long downloading GEO_URL may take
+var geoURLTimeout = envDuration("GEO_URL_TIMEOUT", time.Minute)
+
+// Largest download of GEO_URL accepted, and largest database unpacked
+// from it, so a misconfigured URL can't exhaust memory
+var geoURLMaxBytes = int64(envInt("GEO_URL_MAX_BYTES", 1<<30))
+
+// Client GEO_URL is downloaded with, replaceable with one trusting a test
+// server
+var downloadClient = http.DefaultClient
+
+// Downloads the database from GEO_URL to the path, verifying it against
+// GEO_SHA256 when set. The download may be the database itself, gzipped,
+// or a .tar.gz archive containing it as MaxMind serves them. The file at
+// the path is only replaced once the download has been verified.
+func downloadDatabase(ctx context.Context, path string) error {
+	parsed, err := url.Parse(geoURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("GEO_URL must be an https URL")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, geoURLTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, geoURL, nil)
+	if err != nil {
+		return err
+	}
+	if geoURLToken != "" {
+		req.Header.Set("Authorization", "Bearer "+geoURLToken)
+	} else if geoURLUser != "" {
+		req.SetBasicAuth(geoURLUser, geoURLPassword)
+	}
+
+	res, err := downloadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download GEO_URL: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download GEO_URL: %s", res.Status)
+	}
+
+	body, err := readLimited(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download GEO_URL: %w", err)
+	}
+
+	if geoSHA256 != "" {
+		sum := sha256.Sum256(body)
+		if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, strings.TrimSpace(geoSHA256)) {
+			return fmt.Errorf("GEO_URL checksum mismatch: expected %s, got %s", geoSHA256, actual)
+		}
+	}
+
+	database, err := unpackDatabase(body)
+	if err != nil {
+		return fmt.Errorf("failed to unpack GEO_URL: %w", err)
+	}
+
+	// Write next to the destination and rename over it so a reader never
+	// sees a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".geoip-download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(database); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	slog.Info("downloaded database", "url", parsed.Redacted(), "file", path, "bytes", len(database))
+	return nil
+}
+
+// Gets the database out of a download, decompressing it if it's gzipped
+// and extracting the first .mmdb file if it's a tar archive
+func unpackDatabase(body []byte) ([]byte, error) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		if body, err = readLimited(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	// Tar archives have the "ustar" magic at offset 257 of the header
+	if len(body) < 262 || string(body[257:262]) != "ustar" {
+		return body, nil
+	}
+
+	tr := tar.NewReader(bytes.NewReader(body))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no .mmdb file in the archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag == tar.TypeReg && strings.HasSuffix(header.Name, ".mmdb") {
+			return readLimited(tr)
+		}
+	}
+}
+
+// Reads everything from the reader, failing once more than
+// GEO_URL_MAX_BYTES has been read
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, geoURLMaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > geoURLMaxBytes {
+		return nil, fmt.Errorf("larger than the GEO_URL_MAX_BYTES maximum of %d bytes", geoURLMaxBytes)
+	}
+	return data, nil
+}
+
+// Gets where a database downloaded from GEO_URL is written, GEO_FILE when
+// it's set and otherwise a file in the temp directory
+func downloadPath() string {
+	if geoFile != "" {
+		return geoFile
+	}
+	return filepath.Join(os.TempDir(), "geoip-"+filepath.Base(defaultGeoFile))
+}
+
+// How often to download GEO_URL again and swap in the database if it's
+// newer, 0 disables refreshing
+var geoRefreshInterval = envDuration("GEO_REFRESH_INTERVAL", 0)
+
+// Downloads GEO_URL every GEO_REFRESH_INTERVAL, plus up to a tenth of
+// the interval of jitter so a fleet of instances doesn't hit the server
+// at the same moment. Stops when the context is done, cancelling a
+// download in progress.
+func refreshLoop(ctx context.Context) {
+	for {
+		jitter := time.Duration(rand.Int64N(int64(geoRefreshInterval/10) + 1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(geoRefreshInterval + jitter):
+		}
+
+		if err := refreshDatabase(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			refreshFailures.Inc()
+			slog.Error("failed to refresh database, keeping the current one", "error", err)
+		}
+	}
+}
+
+// Downloads GEO_URL next to GEO_FILE and, if its build is newer than the
+// current database, moves it over GEO_FILE and reloads it
+func refreshDatabase(ctx context.Context) error {
+	staging := geoFile + ".new"
+	defer os.Remove(staging)
+
+	if err := downloadDatabase(ctx, staging); err != nil {
+		return err
+	}
+
+	reader, err := geoip2.Open(staging)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded database: %w", err)
+	}
+	metadata := reader.Metadata()
+	reader.Close()
+	if err := validateMetadata(metadata); err != nil {
+		return fmt.Errorf("downloaded database is invalid: %w", err)
+	}
+	buildEpoch := metadata.BuildEpoch
+
+	if current, ok := geoDb.Metadata(); ok && buildEpoch <= current.BuildEpoch {
+		slog.Info("database is up to date", "build_epoch", buildEpoch)
+		return nil
+	}
+
+	if err := os.Rename(staging, geoFile); err != nil {
+		return err
+	}
+	reloadDatabase()
+	return nil
+}
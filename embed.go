@@ -0,0 +1,15 @@
+//go:build embed_db
+
+package main
+
+import _ "embed"
+
+// The database bundled into the binary when it's built with the embed_db
+// tag, read from GeoLite2-City.mmdb next to the source
+//
+//go:embed GeoLite2-City.mmdb
+var embeddedDb []byte
+
+// Whether the database is bundled into the binary instead of read from
+// GEO_FILE
+const dbEmbedded = true
@@ -0,0 +1,23 @@
+//go:build embed_db
+
+package main
+
+import (
+	"testing"
+)
+
+func TestEmbeddedDatabase(t *testing.T) {
+	readers, err := openReaders(openEmbeddedDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, reader := range readers {
+			reader.Close()
+		}
+	}()
+
+	if got := readers[0].Metadata.DatabaseType; got != "GeoLite2-City" {
+		t.Errorf("got a %s database embedded, want GeoLite2-City", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// The optional databases a geo response can draw on, in a fixed order so
+// each has its own place in the ETag
+func optionalDatabases() []*geoip2.Reader {
+	return []*geoip2.Reader{asnDb, anonDb, connTypeDb, domainDb, ispDb, countryDb}
+}
+
+// Sets ETag and Last-Modified headers on geo responses and answers
+// conditional requests with a 304. Lookups only change when a database or
+// the service does, so the ETag is derived from the build epoch of every
+// loaded database, the service version, and everything identifying the
+// requested representation. A reload with a newer build of any database,
+// or a deploy of another version, invalidates every tag.
+func conditionalMiddleware(c *gin.Context) {
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		c.Next()
+		return
+	}
+
+	// What a `host` resolves to can change without the database changing
+	metadata, ok := geoDb.Metadata()
+	if _, hasHost := c.GetQuery("host"); !ok || hasHost {
+		c.Next()
+		return
+	}
+
+	// Without an `ip` parameter the caller's own address is looked up, so
+	// it's part of what identifies the response
+	_, hasIP := c.GetQuery("ip")
+	hash := sha256.New()
+	hash.Write([]byte(version))
+	hash.Write([]byte{0})
+	hash.Write([]byte(strconv.FormatUint(uint64(metadata.BuildEpoch), 10)))
+	hash.Write([]byte{0})
+	built := metadata.BuildEpoch
+	for _, db := range optionalDatabases() {
+		// Unloaded databases still take their place, so loading one
+		// changes the tag too
+		var epoch uint
+		if db != nil {
+			epoch = db.Metadata().BuildEpoch
+		}
+		built = max(built, epoch)
+		hash.Write([]byte(strconv.FormatUint(uint64(epoch), 10)))
+		hash.Write([]byte{0})
+	}
+	hash.Write([]byte(c.Request.URL.Path + "?" + c.Request.URL.RawQuery))
+	hash.Write([]byte{0})
+	hash.Write([]byte(c.GetHeader("Accept")))
+	if !hasIP {
+		hash.Write([]byte{0})
+		hash.Write([]byte(clientIP(c).String()))
+	}
+
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	modified := time.Unix(int64(built), 0).UTC()
+
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", modified.Format(http.TimeFormat))
+
+	if notModified(c, etag, modified, hasIP) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	c.Next()
+}
+
+// Whether the client's cached copy is still current. If-None-Match takes
+// precedence over If-Modified-Since, which is only honored when the `ip`
+// was given since the URL alone doesn't identify self lookups.
+func notModified(c *gin.Context, etag string, modified time.Time, hasIP bool) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since := c.GetHeader("If-Modified-Since"); since != "" && hasIP {
+		if t, err := http.ParseTime(since); err == nil {
+			return !modified.After(t)
+		}
+	}
+	return false
+}
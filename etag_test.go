@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// Serves a conditional GET of the target with the header
+func getConditional(target string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set(header, value)
+	return serveRequest(req)
+}
+
+func TestConditionalRequests(t *testing.T) {
+	w := get("/geo/zip?ip=81.2.69.142")
+	etag := w.Header().Get("ETag")
+	modified := w.Header().Get("Last-Modified")
+	if etag == "" || modified != "Tue, 30 Nov 2021 10:31:07 GMT" {
+		t.Fatalf("got ETag %q and Last-Modified %q", etag, modified)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"matching etag", "If-None-Match", etag, 304},
+		{"weak matching etag", "If-None-Match", "W/" + etag, 304},
+		{"one of the etags", "If-None-Match", `"stale", ` + etag, 304},
+		{"stale etag", "If-None-Match", `"stale"`, 200},
+		{"modified since", "If-Modified-Since", "Mon, 29 Nov 2021 00:00:00 GMT", 200},
+		{"not modified since", "If-Modified-Since", modified, 304},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := getConditional("/geo/zip?ip=81.2.69.142", tt.header, tt.value)
+			if w.Code != tt.status {
+				t.Fatalf("got status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == 304 && w.Body.Len() != 0 {
+				t.Errorf("got a body %q with a 304", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestConditionalRequestsDifferentIP(t *testing.T) {
+	etag := get("/geo/zip?ip=81.2.69.142").Header().Get("ETag")
+
+	if w := getConditional("/geo/zip?ip=216.160.83.56", "If-None-Match", etag); w.Code != 200 {
+		t.Errorf("got status %d for the ETag of another IP, want 200", w.Code)
+	}
+}
+
+func TestConditionalRequestsSelfLookup(t *testing.T) {
+	// The URL alone doesn't identify the response of a self lookup
+	req := httptest.NewRequest("GET", "/geo/zip", nil)
+	req.RemoteAddr = "81.2.69.142:1234"
+	req.Header.Set("If-Modified-Since", "Tue, 30 Nov 2021 10:31:07 GMT")
+	if w := serveRequest(req); w.Code != 200 {
+		t.Errorf("got status %d for If-Modified-Since without an ip, want 200", w.Code)
+	}
+}
+
+// Opens an ASN database built at the time for the duration of the test
+func openTestASNDatabase(t testing.TB, built time.Time) *geoip2.Reader {
+	t.Helper()
+
+	reader, err := geoip2.FromBytes(buildTestDatabase(t, "GeoLite2-ASN", built, testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"autonomous_system_number": uint32(20712),
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+func TestConditionalRequestsOptionalDatabase(t *testing.T) {
+	setConfig(t, &asnDb, openTestASNDatabase(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	w := get("/geo/lookup?ip=81.2.69.142")
+	etag := w.Header().Get("ETag")
+	if modified := w.Header().Get("Last-Modified"); modified != "Mon, 01 Jan 2024 00:00:00 GMT" {
+		t.Errorf("got Last-Modified %q, want the build time of the newest database", modified)
+	}
+
+	// Only the ASN database is reloaded with a newer build
+	setConfig(t, &asnDb, openTestASNDatabase(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+	w = getConditional("/geo/lookup?ip=81.2.69.142", "If-None-Match", etag)
+	if w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Errorf("got status %d and ETag %s after the ASN database changed, want 200 and a new tag", w.Code, w.Header().Get("ETag"))
+	}
+	if w := getConditional("/geo/lookup?ip=81.2.69.142", "If-Modified-Since", "Mon, 01 Jan 2024 00:00:00 GMT"); w.Code != 200 {
+		t.Errorf("got status %d for If-Modified-Since the old ASN build, want 200", w.Code)
+	}
+
+	// Unloading it changes the tag too
+	unloaded := w.Header().Get("ETag")
+	setConfig(t, &asnDb, nil)
+	if got := get("/geo/lookup?ip=81.2.69.142").Header().Get("ETag"); got == unloaded {
+		t.Error("got the same ETag once the ASN database was unloaded")
+	}
+}
+
+func TestConditionalRequestsVersion(t *testing.T) {
+	etag := get("/geo/zip?ip=81.2.69.142").Header().Get("ETag")
+
+	setConfig(t, &version, "v2.0.0")
+	if w := getConditional("/geo/zip?ip=81.2.69.142", "If-None-Match", etag); w.Code != 200 {
+		t.Errorf("got status %d for the ETag of another version, want 200", w.Code)
+	}
+}
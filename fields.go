@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Fields that can be selected from the combined `/geo` endpoint with the
+// `fields` query parameter, mapped to how each is read from the record.
+// Along with the keys of `GeoResponse`, the single-value keys returned by
+// the other endpoints are accepted.
+var geoFields = map[string]func(*cityRecord, string) interface{}{
+	"country":      func(r *cityRecord, lang string) interface{} { return newCountry(r, lang) },
+	"country_code": func(r *cityRecord, lang string) interface{} { return r.Country.IsoCode },
+	"country_name": func(r *cityRecord, lang string) interface{} { return localName(r.Country.Names, lang) },
+	"is_eu":        func(r *cityRecord, lang string) interface{} { return r.Country.IsInEuropeanUnion },
+	"subdivisions": func(r *cityRecord, lang string) interface{} { return newSubdivisions(r, lang) },
+	"city":         func(r *cityRecord, lang string) interface{} { return localName(r.City.Names, lang) },
+	"postal":       func(r *cityRecord, lang string) interface{} { return r.Postal.Code },
+	"zip":          func(r *cityRecord, lang string) interface{} { return r.Postal.Code },
+	"location":     func(r *cityRecord, lang string) interface{} { return newGeoResponse(r, lang).Location },
+	"point": func(r *cityRecord, lang string) interface{} {
+		return []float64{r.Location.Latitude, r.Location.Longitude}
+	},
+	"time_zone":  func(r *cityRecord, lang string) interface{} { return r.Location.TimeZone },
+	"metro_code": func(r *cityRecord, lang string) interface{} { return r.Location.MetroCode },
+}
+
+// Gets the field names requested with the `fields` query parameter, or nil
+// when every field should be returned. Unknown fields end the request with
+// a 400 and the second parameter returned is false.
+func getFields(c *gin.Context) ([]string, bool) {
+	value := c.Query("fields")
+	if value == "" {
+		return nil, true
+	}
+
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if _, ok := geoFields[field]; !ok {
+			c.AbortWithStatusJSON(400, gin.H{
+				"error":        fmt.Sprintf("unknown field %q", field),
+				"valid_fields": validFields(),
+			})
+			return nil, false
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, true
+}
+
+// Builds a response containing only the given fields of the record, with
+// names in the given locale
+func selectFields(record *cityRecord, fields []string, lang string) gin.H {
+	response := gin.H{}
+	for _, field := range fields {
+		response[field] = geoFields[field](record, lang)
+	}
+	return response
+}
+
+// The sorted names of every selectable field
+func validFields() []string {
+	names := make([]string, 0, len(geoFields))
+	for name := range geoFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const geoJSONContentType = "application/geo+json"
+
+// GeoJSONFeature is a GeoJSON Feature with a Point geometry
+type GeoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   GeoJSONPoint      `json:"geometry"`
+	Properties GeoJSONProperties `json:"properties"`
+}
+
+// GeoJSONPoint is a GeoJSON Point. Note the coordinates are [lon, lat],
+// the reverse of the `point` array returned by `/geo/point`.
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// GeoJSONProperties are the properties of the point feature
+type GeoJSONProperties struct {
+	IP               string `json:"ip"`
+	AccuracyRadiusKm uint16 `json:"accuracy_radius_km"`
+}
+
+// Whether the client asked for GeoJSON with `format=geojson` or the
+// `Accept: application/geo+json` header
+func wantsGeoJSON(c *gin.Context) bool {
+	return c.Query("format") == "geojson" ||
+		strings.Contains(c.GetHeader("Accept"), geoJSONContentType)
+}
+
+// Builds the GeoJSON feature for the location of the IP
+func newGeoJSONFeature(ip string, record *cityRecord) GeoJSONFeature {
+	return GeoJSONFeature{
+		Type: "Feature",
+		Geometry: GeoJSONPoint{
+			Type:        "Point",
+			Coordinates: [2]float64{record.Location.Longitude, record.Location.Latitude},
+		},
+		Properties: GeoJSONProperties{
+			IP:               ip,
+			AccuracyRadiusKm: record.Location.AccuracyRadius,
+		},
+	}
+}
+
+// Writes the feature with the GeoJSON content type, with the keys of its
+// properties following the `schema` requested
+func renderGeoJSON(c *gin.Context, feature GeoJSONFeature) {
+	keys, ok := getSchema(c)
+	if !ok {
+		return
+	}
+
+	// Only the properties are renamed, the rest is fixed by GeoJSON itself
+	properties, err := applySchema(feature.Properties, keys)
+	if err != nil {
+		c.Error(err)
+		c.AbortWithStatusJSON(500, gin.H{"error": "failed to encode response"})
+		return
+	}
+
+	c.Header("Content-Type", geoJSONContentType)
+	c.JSON(200, gin.H{
+		"type":       feature.Type,
+		"geometry":   feature.Geometry,
+		"properties": properties,
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPointGeoJSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "/geo/point?ip=81.2.69.142", nil)
+	req.Header.Set("Accept", geoJSONContentType)
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"format parameter": get("/geo/point?ip=81.2.69.142&format=geojson"),
+		"accept header":    serveRequest(req),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var feature GeoJSONFeature
+			decodeResponse(t, w, 200, &feature)
+
+			if got := w.Header().Get("Content-Type"); got != geoJSONContentType {
+				t.Errorf("got Content-Type %q, want %s", got, geoJSONContentType)
+			}
+			if feature.Type != "Feature" || feature.Geometry.Type != "Point" {
+				t.Errorf("got a %s of a %s, want a Feature of a Point", feature.Type, feature.Geometry.Type)
+			}
+			// GeoJSON puts the longitude first
+			if feature.Geometry.Coordinates != [2]float64{1.3032, 52.6259} {
+				t.Errorf("got coordinates %v, want [1.3032 52.6259]", feature.Geometry.Coordinates)
+			}
+			if feature.Properties.IP != "81.2.69.142" || feature.Properties.AccuracyRadiusKm != 200 {
+				t.Errorf("got properties %+v", feature.Properties)
+			}
+		})
+	}
+}
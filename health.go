@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A known-good IP looked up by the self-test to check the database can
+// actually serve lookups, not just that it opened
+var selfTestIP = envString("SELF_TEST_IP", "8.8.8.8")
+
+// How often the self-test runs
+var selfTestInterval = envDuration("SELF_TEST_INTERVAL", 30*time.Second)
+
+// How long the self-test may keep failing before the instance is reported
+// not ready, so a single blip doesn't pull it out of rotation
+var selfTestThreshold = envDuration("SELF_TEST_THRESHOLD", time.Minute)
+
+// How old the database build may be before the instance is reported not
+// ready, 0 disables the check. MaxMind publishes updates about weekly, so
+// a much older database usually means the updater is broken.
+var maxDbAge = envDuration("MAX_DB_AGE", 0)
+
+// Gets how long ago the current database was built. The second parameter
+// returned is false when no database is loaded.
+func databaseAge() (time.Duration, bool) {
+	metadata, ok := geoDb.Metadata()
+	if !ok {
+		return 0, false
+	}
+	return time.Since(time.Unix(int64(metadata.BuildEpoch), 0)), true
+}
+
+// Whether the database is within MAX_DB_AGE, always true when the check
+// is disabled
+func databaseFresh() bool {
+	if maxDbAge <= 0 {
+		return true
+	}
+
+	age, ok := databaseAge()
+	return ok && age <= maxDbAge
+}
+
+// selfTest is the cached outcome of the background database self-test
+type selfTest struct {
+	mu        sync.Mutex
+	passed    bool
+	failingAt time.Time
+}
+
+var dbSelfTest = &selfTest{}
+
+// Looks up the self-test IP and records whether it resolved
+func (t *selfTest) run() {
+	record, err := cityLookup(net.ParseIP(selfTestIP))
+	passed := err == nil && !isEmptyRecord(record)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if passed {
+		t.passed = true
+		t.failingAt = time.Time{}
+		return
+	}
+
+	if t.failingAt.IsZero() {
+		t.failingAt = time.Now()
+	}
+	logArgs := []any{"ip", selfTestIP}
+	if err != nil {
+		logArgs = append(logArgs, "error", err)
+	}
+	slog.Error("database self-test failed", logArgs...)
+}
+
+// Whether the database has passed a self-test and hasn't been failing it
+// for longer than the threshold
+func (t *selfTest) healthy() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.passed {
+		return false
+	}
+	return t.failingAt.IsZero() || time.Since(t.failingAt) <= selfTestThreshold
+}
+
+// Runs the self-test every interval until the context is done
+func (t *selfTest) loop(ctx context.Context) {
+	ticker := time.NewTicker(selfTestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.run()
+		}
+	}
+}
+
+// Readiness probe, OK only once the database is loaded, passing its
+// self-test, and no older than MAX_DB_AGE, so traffic isn't routed to an
+// instance that can't answer it. The self-test result is cached by the
+// background self-test to keep the probe cheap.
+func readyHandler(c *gin.Context) {
+	if !dbSelfTest.healthy() || !databaseFresh() {
+		c.String(503, "Not Ready")
+		return
+	}
+
+	c.String(200, "OK")
+}
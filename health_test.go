@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+func TestReadyz(t *testing.T) {
+	setConfig(t, &dbSelfTest, &selfTest{})
+	useDatabase(t)
+
+	dbSelfTest.run()
+	if w := get("/readyz"); w.Code != 503 {
+		t.Errorf("got status %d without a database, want 503", w.Code)
+	}
+
+	geoDb.Swap(openTestReaders(t, len(geoDb.shards)))
+	dbSelfTest.run()
+	if w := get("/readyz"); w.Code != 200 {
+		t.Errorf("got status %d with the database loaded, want 200", w.Code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	useDatabase(t)
+
+	// Liveness doesn't depend on the database
+	if w := get("/healthz"); w.Code != 200 || w.Body.String() != "OK" {
+		t.Errorf("got %d %q, want 200 OK", w.Code, w.Body.String())
+	}
+}
+
+func TestSelfTestFailing(t *testing.T) {
+	setConfig(t, &dbSelfTest, &selfTest{})
+	setConfig(t, &selfTestThreshold, 50*time.Millisecond)
+
+	dbSelfTest.run()
+	if !dbSelfTest.healthy() {
+		t.Fatal("unhealthy after passing the self-test")
+	}
+
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		return nil, errors.New("corrupt database")
+	})
+	dbSelfTest.run()
+	if !dbSelfTest.healthy() {
+		t.Error("unhealthy as soon as the self-test failed, before the threshold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	dbSelfTest.run()
+	if dbSelfTest.healthy() {
+		t.Error("still healthy after failing the self-test past the threshold")
+	}
+	if w := get("/readyz"); w.Code != 503 {
+		t.Errorf("got status %d from /readyz, want 503", w.Code)
+	}
+}
+
+func TestReadyzStaleDatabase(t *testing.T) {
+	setConfig(t, &dbSelfTest, &selfTest{})
+	setConfig(t, &maxDbAge, 30*24*time.Hour)
+	reader, err := maxminddb.FromBytes(buildTestDatabase(t, "GeoIP2-City", time.Now().Add(-60*24*time.Hour),
+		testNetwork{"8.8.8.0/24", map[string]interface{}{"country": map[string]interface{}{"iso_code": "US"}}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useDatabase(t, reader)
+	dbSelfTest.run()
+
+	if w := get("/readyz"); w.Code != 503 {
+		t.Errorf("got status %d with a database older than MAX_DB_AGE, want 503", w.Code)
+	}
+
+	setConfig(t, &maxDbAge, 90*24*time.Hour)
+	if w := get("/readyz"); w.Code != 200 {
+		t.Errorf("got status %d with a database within MAX_DB_AGE, want 200", w.Code)
+	}
+}
+
+func TestHeadRequests(t *testing.T) {
+	setConfig(t, &dbSelfTest, &selfTest{})
+	dbSelfTest.run()
+	server := httptest.NewServer(newRouter())
+	defer server.Close()
+
+	for _, path := range []string{"/healthz", "/readyz", "/geo/zip?ip=81.2.69.142"} {
+		t.Run(path, func(t *testing.T) {
+			resp, err := http.Head(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != 200 || len(body) != 0 {
+				t.Errorf("got %d %q, want 200 without a body", resp.StatusCode, body)
+			}
+		})
+	}
+}
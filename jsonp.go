@@ -0,0 +1,39 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether responses may be wrapped in the function named by the
+// `callback` parameter, for browser integrations that can't use CORS
+var enableJSONP = envBool("ENABLE_JSONP", false)
+
+// Callback names allowed for JSONP, a JavaScript identifier optionally
+// namespaced with dots (e.g. "jQuery.cb_1"), so nothing else can be
+// injected into the script
+var jsonpCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// Longest JSONP callback name accepted
+const maxJSONPCallbackLength = 64
+
+// Gets the JSONP callback of the request, when JSONP is enabled and one
+// was given. An invalid callback ends the request with a 400 and the
+// second parameter returned is false.
+func getJSONPCallback(c *gin.Context) (string, bool) {
+	if !enableJSONP {
+		return "", true
+	}
+
+	callback := c.Query("callback")
+	if callback == "" {
+		return "", true
+	}
+
+	if len(callback) > maxJSONPCallbackLength || !jsonpCallbackPattern.MatchString(callback) {
+		c.AbortWithStatusJSON(400, gin.H{"error": "invalid callback parameter"})
+		return "", false
+	}
+	return callback, true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestJSONP(t *testing.T) {
+	setConfig(t, &enableJSONP, true)
+
+	w := get("/geo/zip?ip=81.2.69.142&callback=jQuery.cb_1")
+	if w.Code != 200 {
+		t.Fatalf("got status %d for a valid callback, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/javascript; charset=utf-8" {
+		t.Errorf("got Content-Type %q, want application/javascript", got)
+	}
+	if want := `jQuery.cb_1({"zip":"NR1"});`; w.Body.String() != want {
+		t.Errorf("got body %s, want %s", w.Body.String(), want)
+	}
+
+	// Without a callback, responses are plain JSON
+	if w := get("/geo/zip?ip=81.2.69.142"); w.Body.String() != `{"zip":"NR1"}` {
+		t.Errorf("got body %s without a callback, want plain JSON", w.Body.String())
+	}
+}
+
+func TestJSONPInvalidCallback(t *testing.T) {
+	setConfig(t, &enableJSONP, true)
+
+	for _, callback := range []string{"alert(1);cb", "cb</script>", "1cb", "cb..x", "cb[0]", strings.Repeat("a", 65)} {
+		w := get("/geo/zip?ip=81.2.69.142&callback=" + url.QueryEscape(callback))
+		if w.Code != 400 || w.Body.String() != `{"error":"invalid callback parameter"}` {
+			t.Errorf("got %d %s for callback %q, want a 400", w.Code, w.Body.String(), callback)
+		}
+	}
+}
+
+func TestJSONPDisabled(t *testing.T) {
+	setConfig(t, &enableJSONP, false)
+
+	w := get("/geo/zip?ip=81.2.69.142&callback=cb")
+	if got := w.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("got Content-Type %q with JSONP disabled, want application/json", got)
+	}
+	if w.Body.String() != `{"zip":"NR1"}` {
+		t.Errorf("got body %s with JSONP disabled, want plain JSON", w.Body.String())
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Locale used for place names when none is requested, and as the fallback
+// when a name isn't available in the requested locale
+const defaultLang = "en"
+
+// Locales that MaxMind databases carry names for
+var supportedLangs = []string{"en", "de", "es", "fr", "ja", "pt-BR", "ru", "zh-CN"}
+
+// Gets the locale requested with the `lang` query parameter. Unsupported
+// locales end the request with a 400 and the second parameter returned is
+// false.
+func getLang(c *gin.Context) (string, bool) {
+	lang := c.Query("lang")
+	if lang == "" {
+		return defaultLang, true
+	}
+
+	for _, supported := range supportedLangs {
+		if lang == supported {
+			return lang, true
+		}
+	}
+
+	c.AbortWithStatusJSON(400, gin.H{
+		"error":           fmt.Sprintf("unsupported lang %q", lang),
+		"supported_langs": supportedLangs,
+	})
+	return "", false
+}
+
+// Gets the name in the given locale, falling back to English
+func localName(names map[string]string, lang string) string {
+	if name, ok := names[lang]; ok {
+		return name
+	}
+	return names[defaultLang]
+}
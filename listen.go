@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// IP address of the interface to listen on, all interfaces when unset
+var bindAddress string = os.Getenv("BIND_ADDRESS")
+
+// Builds the TCP address to listen on from BIND_ADDRESS and PORT. Fails
+// when either isn't valid so a typo stops startup instead of exposing the
+// service on the wrong interface.
+func serverAddr() (string, error) {
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return "", fmt.Errorf("PORT must be a port number, got %q", port)
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(bindAddress, "["), "]")
+	if host != "" && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("BIND_ADDRESS must be an IP address, got %q", bindAddress)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Path of a Unix domain socket to listen on instead of a TCP port
+var unixSocket string = os.Getenv("UNIX_SOCKET")
+
+// Certificate and key to terminate TLS with, both must be set to enable it
+var tlsCertFile string = os.Getenv("TLS_CERT_FILE")
+var tlsKeyFile string = os.Getenv("TLS_KEY_FILE")
+
+// Whether the server should terminate TLS itself
+func tlsEnabled() bool {
+	return tlsCertFile != "" && tlsKeyFile != ""
+}
+
+// Checks the TLS configuration before the server starts so a bad
+// certificate fails fast rather than on the first connection
+func validateTLS() error {
+	if tlsCertFile == "" && tlsKeyFile == "" {
+		return nil
+	}
+	if !tlsEnabled() {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must both be set to enable TLS")
+	}
+
+	if _, err := tls.LoadX509KeyPair(tlsCertFile, tlsKeyFile); err != nil {
+		return fmt.Errorf("failed to load TLS_CERT_FILE/TLS_KEY_FILE: %w", err)
+	}
+	return nil
+}
+
+// Serves requests on the listener, over TLS when it's enabled
+func serve(srv *http.Server, listener net.Listener) error {
+	if tlsEnabled() {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return srv.ServeTLS(listener, tlsCertFile, tlsKeyFile)
+	}
+	return srv.Serve(listener)
+}
+
+// Opens the listener for the server, a Unix socket when UNIX_SOCKET is set
+// and otherwise the TCP address of the server
+func listen(srv *http.Server) (net.Listener, error) {
+	if unixSocket == "" {
+		slog.Info("listening", "address", srv.Addr)
+		return net.Listen("tcp", srv.Addr)
+	}
+
+	if os.Getenv("PORT") != "" {
+		slog.Warn("both UNIX_SOCKET and PORT are set, ignoring PORT")
+	}
+
+	// Remove a socket left behind by a previous run that didn't exit
+	// cleanly, but never anything that isn't a socket
+	if info, err := os.Stat(unixSocket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("UNIX_SOCKET %s exists and is not a socket", unixSocket)
+		}
+		if err := os.Remove(unixSocket); err != nil {
+			return nil, err
+		}
+	}
+
+	// The listener unlinks the socket file when it's closed on shutdown
+	listener, err := net.Listen("unix", unixSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the owner and group (e.g. a client sidecar) may connect
+	if err := os.Chmod(unixSocket, 0660); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	slog.Info("listening", "socket", unixSocket)
+	return listener, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenPortInUse(t *testing.T) {
+	setConfig(t, &unixSocket, "")
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	if listener, err := listen(&http.Server{Addr: taken.Addr().String()}); err == nil {
+		listener.Close()
+		t.Fatal("listening on a port in use succeeded")
+	}
+}
+
+func TestServeReturnsServerClosedOnShutdown(t *testing.T) {
+	setConfig(t, &unixSocket, "")
+
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	listener, err := listen(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- serve(srv, listener) }()
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only this error is treated as a clean exit
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("got %v after shutdown, want http.ErrServerClosed", err)
+	}
+}
+
+// Writes a self-signed certificate for 127.0.0.1 and its key to the
+// test's temp dir, returning their paths
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "geoip test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	setConfig(t, &unixSocket, "")
+	setConfig(t, &tlsCertFile, certFile)
+	setConfig(t, &tlsKeyFile, keyFile)
+
+	if err := validateTLS(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: newRouter()}
+	listener, err := listen(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(srv, listener)
+	defer srv.Close()
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err := client.Get("https://" + listener.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != "OK" {
+		t.Errorf("got %d %q over TLS, want 200 OK", resp.StatusCode, body)
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+
+	tests := []struct {
+		name      string
+		cert, key string
+		valid     bool
+	}{
+		{"disabled", "", "", true},
+		{"both set", certFile, keyFile, true},
+		{"only the certificate", certFile, "", false},
+		{"only the key", "", keyFile, false},
+		{"missing files", certFile + ".missing", keyFile, false},
+		{"key as certificate", keyFile, keyFile, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &tlsCertFile, tt.cert)
+			setConfig(t, &tlsKeyFile, tt.key)
+
+			if err := validateTLS(); (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid=%t", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestServerAddr(t *testing.T) {
+	tests := []struct {
+		name        string
+		bindAddress string
+		port        string
+		want        string
+	}{
+		{"all interfaces", "", "3000", ":3000"},
+		{"localhost", "127.0.0.1", "3000", "127.0.0.1:3000"},
+		{"IPv6", "::1", "3000", "[::1]:3000"},
+		{"bracketed IPv6", "[::1]", "3000", "[::1]:3000"},
+		{"hostname", "localhost", "3000", ""},
+		{"address with a port", "127.0.0.1:80", "3000", ""},
+		{"invalid port", "127.0.0.1", "http", ""},
+		{"port out of range", "127.0.0.1", "65536", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setConfig(t, &bindAddress, tt.bindAddress)
+			setConfig(t, &port, tt.port)
+
+			got, err := serverAddr()
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenBindAddress(t *testing.T) {
+	setConfig(t, &unixSocket, "")
+	setConfig(t, &bindAddress, "127.0.0.1")
+	setConfig(t, &port, "0")
+
+	addr, err := serverAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := listen(&http.Server{Addr: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if ip := listener.Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("listening on %s, want only 127.0.0.1", listener.Addr())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Minimum level to log: debug, info, warn, or error
+var logLevel string = os.Getenv("LOG_LEVEL")
+
+// How IP addresses are written to the logs: full, masked (the last octet of
+// IPv4 or last 80 bits of IPv6 zeroed), hashed (a keyed SHA-256), or none
+var logIPMode = envString("LOG_IP_MODE", "full")
+
+// Key of the hashes written with LOG_IP_MODE=hashed. When unset, a random
+// key is used so hashes only correlate within a single run.
+var logIPHashKey = []byte(os.Getenv("LOG_IP_HASH_KEY"))
+
+// Log attributes holding an IP address, rewritten according to LOG_IP_MODE
+var logIPKeys = map[string]bool{"ip": true, "client_ip": true}
+
+// Creates the JSON logger used for all service logs
+func newLogger() (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if logLevel != "" {
+		if err := level.UnmarshalText([]byte(strings.ToUpper(logLevel))); err != nil {
+			return nil, fmt.Errorf("LOG_LEVEL must be debug, info, warn, or error, got %q", logLevel)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	switch logIPMode {
+	case "full":
+	case "masked", "hashed", "none":
+		if logIPMode == "hashed" && len(logIPHashKey) == 0 {
+			logIPHashKey = make([]byte, 32)
+			rand.Read(logIPHashKey)
+		}
+		options.ReplaceAttr = replaceIPAttr
+	default:
+		return nil, fmt.Errorf("LOG_IP_MODE must be full, masked, hashed, or none, got %q", logIPMode)
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+}
+
+// Rewrites the IP address attributes of every log line according to
+// LOG_IP_MODE, so no log can leak a full address
+func replaceIPAttr(groups []string, a slog.Attr) slog.Attr {
+	if !logIPKeys[a.Key] {
+		return a
+	}
+
+	value := a.Value.String()
+	switch logIPMode {
+	case "masked":
+		// A comma-separated `ip` parameter is masked address by address
+		parts := strings.Split(value, ",")
+		for i, part := range parts {
+			parts[i] = maskIP(strings.TrimSpace(part))
+		}
+		return slog.String(a.Key, strings.Join(parts, ","))
+	case "hashed":
+		mac := hmac.New(sha256.New, logIPHashKey)
+		mac.Write([]byte(value))
+		return slog.String(a.Key, hex.EncodeToString(mac.Sum(nil)[:16]))
+	default:
+		return slog.Attr{}
+	}
+}
+
+// Zeroes the host part of an IP address: the last octet of IPv4 or the
+// last 80 bits of IPv6. Values that aren't an IP are replaced entirely,
+// as they may be a mistyped one.
+func maskIP(value string) string {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "invalid"
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// Logs the message at error level and exits after running the cleanups
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	cleanup()
+	os.Exit(1)
+}
+
+// Logs every request with its outcome and the IP that was looked up
+func requestLogger(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	args := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		"client_ip", clientIP(c).String(),
+		"request_id", c.GetString(requestIDKey),
+	}
+	// The normalized address once it's been parsed, otherwise the raw
+	// parameter (e.g. when it was invalid or a list)
+	if ip, ok := c.Get(lookupIPKey); ok {
+		args = append(args, "ip", ip.(net.IP).String())
+	} else if ip, ok := c.GetQuery("ip"); ok {
+		args = append(args, "ip", ip)
+	}
+
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+	slog.Log(c.Request.Context(), level, "request", args...)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestLogIPModes(t *testing.T) {
+	setConfig(t, &logIPHashKey, []byte("test key"))
+	hashPattern := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		mode     string
+		ip       string
+		clientIP string
+	}{
+		{"full", "81.2.69.142,2001:218::1", "216.160.83.56"},
+		{"masked", "81.2.69.0,2001:218::", "216.160.83.0"},
+		{"hashed", "", ""},
+		{"none", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			setConfig(t, &logIPMode, tt.mode)
+			options := &slog.HandlerOptions{}
+			if tt.mode != "full" {
+				options.ReplaceAttr = replaceIPAttr
+			}
+			logs := captureLogs(t, options)
+
+			req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142,2001:218::1", nil)
+			req.RemoteAddr = "216.160.83.56:1234"
+			serveRequest(req)
+			req = httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+			req.RemoteAddr = "216.160.83.56:1234"
+			serveRequest(req)
+
+			lines := logLines(t, logs, "request")
+			if len(lines) != 2 {
+				t.Fatalf("got %d request log lines, want 2", len(lines))
+			}
+			list, single := lines[0], lines[1]
+
+			switch tt.mode {
+			case "hashed":
+				for _, key := range []string{"ip", "client_ip"} {
+					if value, _ := single[key].(string); !hashPattern.MatchString(value) {
+						t.Errorf("got %s %v, want a hash", key, single[key])
+					}
+				}
+				// A key hashes the same address the same way every time
+				if list["client_ip"] != single["client_ip"] || list["ip"] == single["ip"] {
+					t.Errorf("got hashes %v and %v, want them to correlate by address", list, single)
+				}
+			case "none":
+				for _, key := range []string{"ip", "client_ip"} {
+					if value, ok := single[key]; ok {
+						t.Errorf("got %s %v, want it left out", key, value)
+					}
+				}
+			default:
+				if list["ip"] != tt.ip || list["client_ip"] != tt.clientIP {
+					t.Errorf("got ip %v and client_ip %v, want %s and %s", list["ip"], list["client_ip"], tt.ip, tt.clientIP)
+				}
+			}
+		})
+	}
+}
+
+func TestMaskIP(t *testing.T) {
+	for value, want := range map[string]string{
+		"81.2.69.142":          "81.2.69.0",
+		"::ffff:81.2.69.142":   "81.2.69.0",
+		"2001:218:1:2:3:4:5:6": "2001:218:1::",
+		"bogus":                "invalid",
+	} {
+		if got := maskIP(value); got != want {
+			t.Errorf("got %s masking %s, want %s", got, value, want)
+		}
+	}
+}
+
+func TestNewLoggerInvalidIPMode(t *testing.T) {
+	setConfig(t, &logIPMode, "partial")
+
+	if _, err := newLogger(); err == nil || err.Error() != `LOG_IP_MODE must be full, masked, hashed, or none, got "partial"` {
+		t.Errorf("got error %v for an unknown LOG_IP_MODE", err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"golang.org/x/sync/errgroup"
+)
+
+// ASNResponse is the response shape of the `/geo/asn` endpoint
+type ASNResponse struct {
+	ASN uint   `json:"asn" xml:"asn"`
+	Org string `json:"org" xml:"org"`
+}
+
+// AnonymousResponse is the response shape of the `/geo/anonymous` endpoint
+type AnonymousResponse struct {
+	IsAnonymous       bool `json:"is_anonymous" xml:"is_anonymous"`
+	IsAnonymousVPN    bool `json:"is_anonymous_vpn" xml:"is_anonymous_vpn"`
+	IsHostingProvider bool `json:"is_hosting_provider" xml:"is_hosting_provider"`
+	IsPublicProxy     bool `json:"is_public_proxy" xml:"is_public_proxy"`
+	IsTorExitNode     bool `json:"is_tor_exit_node" xml:"is_tor_exit_node"`
+}
+
+// ISPResponse is the response shape of the `/geo/isp` endpoint
+type ISPResponse struct {
+	ISP                          string `json:"isp" xml:"isp"`
+	Organization                 string `json:"organization" xml:"organization"`
+	AutonomousSystemNumber       uint   `json:"autonomous_system_number" xml:"autonomous_system_number"`
+	AutonomousSystemOrganization string `json:"autonomous_system_organization" xml:"autonomous_system_organization"`
+}
+
+// LookupResponse is the response shape of the `/geo/lookup` endpoint, the
+// fields of `/geo` along with a section for each other loaded database
+type LookupResponse struct {
+	*GeoResponse
+	ASN       *ASNResponse       `json:"asn,omitempty" xml:"asn,omitempty"`
+	Anonymous *AnonymousResponse `json:"anonymous,omitempty" xml:"anonymous,omitempty"`
+	ISP       *ISPResponse       `json:"isp,omitempty" xml:"isp,omitempty"`
+
+	ConnectionType string `json:"connection_type,omitempty" xml:"connection_type,omitempty"`
+	Domain         string `json:"domain,omitempty" xml:"domain,omitempty"`
+}
+
+// Builds the ASN response of a record
+func newASNResponse(record *geoip2.ASN) ASNResponse {
+	return ASNResponse{
+		ASN: record.AutonomousSystemNumber,
+		Org: record.AutonomousSystemOrganization,
+	}
+}
+
+// Builds the Anonymous IP response of a record
+func newAnonymousResponse(record *geoip2.AnonymousIP) AnonymousResponse {
+	return AnonymousResponse{
+		IsAnonymous:       record.IsAnonymous,
+		IsAnonymousVPN:    record.IsAnonymousVPN,
+		IsHostingProvider: record.IsHostingProvider,
+		IsPublicProxy:     record.IsPublicProxy,
+		IsTorExitNode:     record.IsTorExitNode,
+	}
+}
+
+// Builds the ISP response of a record
+func newISPResponse(record *geoip2.ISP) ISPResponse {
+	return ISPResponse{
+		ISP:                          record.ISP,
+		Organization:                 record.Organization,
+		AutonomousSystemNumber:       record.AutonomousSystemNumber,
+		AutonomousSystemOrganization: record.AutonomousSystemOrganization,
+	}
+}
+
+// Returns everything every loaded database knows about the IP address in
+// the request from one call. The databases are looked up in parallel, each
+// within LOOKUP_TIMEOUT, and sections for databases that aren't loaded, or
+// don't have the IP, are left out. Responds with a 404 when none of them
+// have it, and a 504 when any of them times out.
+func lookupHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	ip, ok := getLookupIP(c)
+	if !ok {
+		return
+	}
+
+	var response LookupResponse
+	g, ctx := errgroup.WithContext(c.Request.Context())
+
+	g.Go(func() error {
+		record, err := lookupCity(ctx, ip)
+		if errors.Is(err, errNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		geo := newLookupGeoResponse(record, lang)
+		response.GeoResponse = &geo
+		return nil
+	})
+
+	if asnDb != nil {
+		g.Go(func() error {
+			record, err := withLookupTimeout(ctx, func() (*geoip2.ASN, error) { return asnDb.ASN(ip) })
+			if err != nil {
+				return err
+			}
+			addSource(ctx, "asn")
+			if record.AutonomousSystemNumber != 0 {
+				asn := newASNResponse(record)
+				response.ASN = &asn
+			}
+			return nil
+		})
+	}
+
+	if anonDb != nil {
+		g.Go(func() error {
+			record, err := withLookupTimeout(ctx, func() (*geoip2.AnonymousIP, error) { return anonDb.AnonymousIP(ip) })
+			if err != nil {
+				return err
+			}
+			addSource(ctx, "anonymous")
+			anonymous := newAnonymousResponse(record)
+			response.Anonymous = &anonymous
+			return nil
+		})
+	}
+
+	if connTypeDb != nil {
+		g.Go(func() error {
+			record, err := withLookupTimeout(ctx, func() (*geoip2.ConnectionType, error) { return connTypeDb.ConnectionType(ip) })
+			if err != nil {
+				return err
+			}
+			addSource(ctx, "connection-type")
+			response.ConnectionType = record.ConnectionType
+			return nil
+		})
+	}
+
+	if domainDb != nil {
+		g.Go(func() error {
+			record, err := withLookupTimeout(ctx, func() (*geoip2.Domain, error) { return domainDb.Domain(ip) })
+			if err != nil {
+				return err
+			}
+			addSource(ctx, "domain")
+			response.Domain = record.Domain
+			return nil
+		})
+	}
+
+	if ispDb != nil {
+		g.Go(func() error {
+			record, err := withLookupTimeout(ctx, func() (*geoip2.ISP, error) { return ispDb.ISP(ip) })
+			if err != nil {
+				return err
+			}
+			addSource(ctx, "isp")
+			if record.ISP != "" || record.Organization != "" || record.AutonomousSystemNumber != 0 {
+				isp := newISPResponse(record)
+				response.ISP = &isp
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		respondLookupError(c, ip, err)
+		return
+	}
+
+	if response.GeoResponse == nil && response.ASN == nil && response.Anonymous == nil &&
+		response.ISP == nil && response.ConnectionType == "" && response.Domain == "" {
+		c.AbortWithStatusJSON(404, gin.H{"error": "ip not found in database"})
+		return
+	}
+
+	render(c, 200, response)
+}
+
+// Ends the request with the status for a failed lookup
+func respondLookupError(c *gin.Context, ip net.IP, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		c.AbortWithStatusJSON(404, gin.H{"error": "ip not found in database"})
+	case errors.Is(err, context.DeadlineExceeded):
+		c.AbortWithStatusJSON(504, gin.H{"error": "lookup timed out"})
+	case errors.Is(err, errNoDatabase):
+		c.AbortWithStatusJSON(503, gin.H{"error": "database not loaded"})
+	default:
+		// Log and fail only this request; the service keeps serving others
+		slog.Error("lookup failed", "ip", ip.String(), "error", err)
+		c.AbortWithStatus(500)
+	}
+}
+
+// Looks up an IP in one of the optional databases, returning the response
+// for it or `errNotFound` when the database doesn't have it
+type optionalLookup func(ip net.IP) (interface{}, error)
+
+// Looks up the IP address in the request in an optional database and
+// writes the response, failing the same way the city routes do: a 501
+// when the database isn't configured, a 422 for a private or reserved IP,
+// a 404 when the database doesn't have the IP, and a 504 once
+// LOOKUP_TIMEOUT has passed. The source is named in X-Geo-Source.
+func respondOptionalLookup(c *gin.Context, name string, configured bool, source string, lookup optionalLookup) {
+	if !configured {
+		c.AbortWithStatusJSON(501, gin.H{"error": name + " database not configured"})
+		return
+	}
+
+	ip, ok := getLookupIP(c)
+	if !ok {
+		return
+	}
+
+	response, err := withLookupTimeout(c.Request.Context(), func() (interface{}, error) { return lookup(ip) })
+	if err != nil {
+		respondLookupError(c, ip, err)
+		return
+	}
+
+	addSource(c.Request.Context(), source)
+	render(c, 200, response)
+}
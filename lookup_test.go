@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestLookupHandler(t *testing.T) {
+	setConfig(t, &asnDb, openTestGeoIP2(t, "GeoLite2-ASN", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"autonomous_system_number":       uint32(20712),
+		"autonomous_system_organization": "Andrews & Arnold Ltd",
+	}}))
+	setConfig(t, &anonDb, openTestGeoIP2(t, "GeoIP2-Anonymous-IP", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"is_anonymous":    true,
+		"is_public_proxy": true,
+	}}))
+	setConfig(t, &ispDb, nil)
+	setConfig(t, &connTypeDb, nil)
+	setConfig(t, &domainDb, nil)
+
+	var body LookupResponse
+	decodeResponse(t, get("/geo/lookup?ip=81.2.69.142"), 200, &body)
+
+	if body.GeoResponse == nil || body.Country.IsoCode != "GB" || body.City != "Norwich" {
+		t.Errorf("got city fields %+v, want Norwich, GB", body.GeoResponse)
+	}
+	if want := (ASNResponse{ASN: 20712, Org: "Andrews & Arnold Ltd"}); body.ASN == nil || *body.ASN != want {
+		t.Errorf("got ASN %+v, want %+v", body.ASN, want)
+	}
+	if want := (AnonymousResponse{IsAnonymous: true, IsPublicProxy: true}); body.Anonymous == nil || *body.Anonymous != want {
+		t.Errorf("got anonymity %+v, want %+v", body.Anonymous, want)
+	}
+	if body.ISP != nil || body.ConnectionType != "" || body.Domain != "" {
+		t.Errorf("got sections %+v for databases that aren't loaded", body)
+	}
+
+	// Sections of databases without the IP are left out
+	body = LookupResponse{}
+	decodeResponse(t, get("/geo/lookup?ip=216.160.83.56"), 200, &body)
+	if body.GeoResponse == nil || body.Country.IsoCode != "US" || body.ASN != nil {
+		t.Errorf("got %+v for an IP only the city database has", body)
+	}
+}
+
+func TestLookupHandlerNotFound(t *testing.T) {
+	setConfig(t, &asnDb, nil)
+	setConfig(t, &anonDb, nil)
+	setConfig(t, &ispDb, nil)
+	setConfig(t, &connTypeDb, nil)
+	setConfig(t, &domainDb, nil)
+
+	if w := get("/geo/lookup?ip=3000::1"); w.Code != 404 {
+		t.Errorf("got status %d for an IP no database has, want 404", w.Code)
+	}
+}
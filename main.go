@@ -2,25 +2,148 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var serviceMode string = os.Getenv("MODE")
 var port string = os.Getenv("PORT")
+var geoFile string = os.Getenv("GEO_FILE")
 
-var geoDb *geoip2.Reader
+// Where the database is expected when GEO_FILE isn't set
+const defaultGeoFile = "/data/GeoLite2-City.mmdb"
+
+var asnFile string = os.Getenv("ASN_FILE")
+var anonFile string = os.Getenv("ANON_FILE")
+var connTypeFile string = os.Getenv("CONNTYPE_FILE")
+var domainFile string = os.Getenv("DOMAIN_FILE")
+var ispFile string = os.Getenv("ISP_FILE")
+var countryFile string = os.Getenv("COUNTRY_FILE")
+var routePrefix string = os.Getenv("ROUTE_PREFIX")
+
+var geoDb = newDatabase(readerPoolSize)
+
+// Optional GeoLite2-ASN database, nil when ASN_FILE isn't set
+var asnDb *geoip2.Reader
+
+// Optional GeoIP2-Anonymous-IP database, nil when ANON_FILE isn't set
+var anonDb *geoip2.Reader
+
+// Optional GeoIP2-Connection-Type database, nil when CONNTYPE_FILE isn't set
+var connTypeDb *geoip2.Reader
+
+// Optional GeoIP2-Domain database, nil when DOMAIN_FILE isn't set
+var domainDb *geoip2.Reader
+
+// Optional GeoIP2-ISP database, nil when ISP_FILE isn't set
+var ispDb *geoip2.Reader
+
+// Optional GeoLite2-Country database used for addresses the city database
+// doesn't have a country for, nil when COUNTRY_FILE isn't set
+var countryDb *geoip2.Reader
+
+// GeoResponse is the response shape of the combined `/geo` endpoint
+type GeoResponse struct {
+	Country      Country  `json:"country" xml:"country"`
+	Subdivisions []Place  `json:"subdivisions" xml:"subdivisions"`
+	City         string   `json:"city" xml:"city"`
+	Postal       string   `json:"postal" xml:"postal"`
+	Location     Location `json:"location" xml:"location"`
+	TimeZone     string   `json:"time_zone" xml:"time_zone"`
+	MetroCode    uint     `json:"metro_code" xml:"metro_code"`
+
+	// Network of the database the IP matched, e.g. "81.2.68.0/23"
+	Network string `json:"network,omitempty" xml:"network,omitempty"`
+
+	// Confidence (0-100) in the city and postal code, only set with an
+	// Enterprise database
+	CityConfidence   *uint8 `json:"city_confidence,omitempty" xml:"city_confidence,omitempty"`
+	PostalConfidence *uint8 `json:"postal_confidence,omitempty" xml:"postal_confidence,omitempty"`
+}
+
+// Place is a named region identified by its ISO code
+type Place struct {
+	IsoCode string `json:"iso_code" xml:"iso_code"`
+	Name    string `json:"name" xml:"name"`
+}
+
+// Country is a country along with whether it's a member of the EU
+type Country struct {
+	Place
+	IsEU bool `json:"is_eu" xml:"is_eu"`
+}
+
+// Location is the approximate coordinates of an IP address. The accuracy
+// radius is in kilometers.
+type Location struct {
+	Latitude       float64 `json:"latitude" xml:"latitude"`
+	Longitude      float64 `json:"longitude" xml:"longitude"`
+	AccuracyRadius uint16  `json:"accuracy_radius" xml:"accuracy_radius"`
+}
+
+// PointResponse is the response shape of the `/geo/point` endpoint. The
+// point is [latitude, longitude].
+type PointResponse struct {
+	Point            []float64 `json:"point" xml:"point"`
+	AccuracyRadiusKm uint16    `json:"accuracy_radius_km" xml:"accuracy_radius_km"`
+}
+
+// PointObjectResponse is the response shape of the `/geo/point` endpoint
+// with `point_format=object`, naming the coordinates rather than relying on
+// their order
+type PointObjectResponse struct {
+	Point            LatLng `json:"point" xml:"point"`
+	AccuracyRadiusKm uint16 `json:"accuracy_radius_km" xml:"accuracy_radius_km"`
+}
+
+// LatLng is a point with named coordinates
+type LatLng struct {
+	Latitude  float64 `json:"latitude" xml:"latitude"`
+	Longitude float64 `json:"longitude" xml:"longitude"`
+}
+
+// ZipResponse is the response shape of the `/geo/zip` endpoint
+type ZipResponse struct {
+	Zip string `json:"zip" xml:"zip"`
+}
+
+// AccuracyResponse is the response shape of the `/geo/accuracy` endpoint,
+// summarizing how much of the record is known. The confidence scores are
+// only set for Enterprise databases.
+type AccuracyResponse struct {
+	AccuracyRadiusKm uint16 `json:"accuracy_radius_km" xml:"accuracy_radius_km"`
+	HasCity          bool   `json:"has_city" xml:"has_city"`
+	HasPostal        bool   `json:"has_postal" xml:"has_postal"`
+	HasLocation      bool   `json:"has_location" xml:"has_location"`
+	CityConfidence   *uint8 `json:"city_confidence,omitempty" xml:"city_confidence,omitempty"`
+	PostalConfidence *uint8 `json:"postal_confidence,omitempty" xml:"postal_confidence,omitempty"`
+}
+
+// Methods the probes and geo lookups are served for. A HEAD gets the same
+// status and headers as a GET without the body, for uptime monitors.
+var readMethods = []string{http.MethodGet, http.MethodHead}
 
 func main() {
+	logger, logErr := newLogger()
+	if logErr != nil {
+		fatal("invalid logging configuration", "error", logErr)
+	}
+	slog.SetDefault(logger)
 
 	if serviceMode == "" {
 		serviceMode = "release"
@@ -30,101 +153,1069 @@ func main() {
 		port = "3000"
 	}
 
-	log.Printf("Starting `geoip` service in '%s' mode...\n", serviceMode)
+	// Normalize the prefix to "/api/v1" form, an empty prefix keeps the
+	// routes at "/geo/..."
+	if routePrefix = strings.Trim(routePrefix, "/"); routePrefix != "" {
+		routePrefix = "/" + routePrefix
+	}
 
-	// Set the run mode of gin (release/debug)
-	gin.SetMode(serviceMode)
+	if _, ok := responseSchemas[defaultSchema]; !ok {
+		fatal("RESPONSE_SCHEMA must be one of "+strings.Join(schemaNames(), ", "), "value", defaultSchema)
+	}
 
-	router := gin.New()
+	var proxyErr error
+	trustedProxies, proxyErr = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if proxyErr != nil {
+		fatal("invalid TRUSTED_PROXIES", "error", proxyErr)
+	}
 
-	// Recovery middleware recovers from any panics and writes a 500 if there was one.
-	router.Use(gin.Recovery())
+	slog.Info("starting `geoip` service", "mode", serviceMode)
 
-	router.GET("/healthz", func(c *gin.Context) {
-		c.String(200, "OK")
+	// Open Maxmind database before any route can be served so handlers
+	// never see a nil reader
+	if readerPoolSize < 1 {
+		fatal("READER_POOL_SIZE must be at least 1", "value", readerPoolSize)
+	}
+
+	var readers []*maxminddb.Reader
+	var geoErr error
+	if dbEmbedded {
+		if geoFile != "" {
+			slog.Warn("the database is embedded, ignoring GEO_FILE")
+		}
+		readers, geoErr = openReaders(openEmbeddedDatabase)
+		if geoErr != nil {
+			fatal("failed to open the embedded database", "error", geoErr)
+		}
+	} else {
+		if geoURL != "" {
+			geoFile = downloadPath()
+			if err := downloadDatabase(context.Background(), geoFile); err != nil {
+				fatal(err.Error(), "file", geoFile)
+			}
+		}
+
+		var pathErr error
+		if geoFile, pathErr = resolveGeoFile(geoFile); pathErr != nil {
+			fatal(pathErr.Error())
+		}
+
+		readers, geoErr = openReaders(func() (*maxminddb.Reader, error) { return maxminddb.Open(geoFile) })
+		if geoErr != nil {
+			fatal("failed to open GEO_FILE", "file", geoFile, "error", geoErr)
+		}
+	}
+	geoDb.Swap(readers)
+	onCleanup(func() { geoDb.Close() })
+
+	if asnFile != "" {
+		asnDb, geoErr = geoip2.Open(asnFile)
+		if geoErr != nil {
+			fatal("failed to open ASN_FILE", "file", asnFile, "error", geoErr)
+		}
+		onCleanup(func() { asnDb.Close() })
+	}
+
+	if anonFile != "" {
+		anonDb, geoErr = geoip2.Open(anonFile)
+		if geoErr != nil {
+			fatal("failed to open ANON_FILE", "file", anonFile, "error", geoErr)
+		}
+		onCleanup(func() { anonDb.Close() })
+	}
+
+	if connTypeFile != "" {
+		connTypeDb, geoErr = geoip2.Open(connTypeFile)
+		if geoErr != nil {
+			fatal("failed to open CONNTYPE_FILE", "file", connTypeFile, "error", geoErr)
+		}
+		onCleanup(func() { connTypeDb.Close() })
+	}
+
+	if domainFile != "" {
+		domainDb, geoErr = geoip2.Open(domainFile)
+		if geoErr != nil {
+			fatal("failed to open DOMAIN_FILE", "file", domainFile, "error", geoErr)
+		}
+		onCleanup(func() { domainDb.Close() })
+	}
+
+	if ispFile != "" {
+		ispDb, geoErr = geoip2.Open(ispFile)
+		if geoErr != nil {
+			fatal("failed to open ISP_FILE", "file", ispFile, "error", geoErr)
+		}
+		onCleanup(func() { ispDb.Close() })
+	}
+
+	if countryFile != "" {
+		countryDb, geoErr = geoip2.Open(countryFile)
+		if geoErr != nil {
+			fatal("failed to open COUNTRY_FILE", "file", countryFile, "error", geoErr)
+		}
+		onCleanup(func() { countryDb.Close() })
+	}
+
+	if net.ParseIP(selfTestIP) == nil {
+		fatal("SELF_TEST_IP must be an IP address", "value", selfTestIP)
+	}
+	if selfTestInterval <= 0 {
+		fatal("SELF_TEST_INTERVAL must be positive", "value", selfTestInterval.String())
+	}
+	dbSelfTest.run()
+	go dbSelfTest.loop(background)
+
+	if err := initCache(); err != nil {
+		fatal("failed to create cache", "error", err)
+	}
+
+	shutdownTracing, tracingErr := initTracing(context.Background())
+	if tracingErr != nil {
+		fatal("failed to set up tracing", "error", tracingErr)
+	}
+
+	onCleanup(func() {
+		// Flush the spans of the last requests before exiting
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
 	})
+	defer cleanup()
+
+	// Set the run mode of gin (release/debug)
+	gin.SetMode(serviceMode)
 
-	router.GET("/geo/point", pointHandler)
-	router.GET("/geo/zip", zipHandler)
+	router := newRouter()
+
+	// Bound how long a connection can take so slow clients can't hold
+	// connections open indefinitely. Defaults to 5s to read the headers,
+	// 10s to read the full request and 10s to write the response, and 60s
+	// for idle keep-alive connections.
+	addr, addrErr := serverAddr()
+	if addrErr != nil {
+		fatal("invalid listen address", "error", addrErr)
+	}
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+		Addr:              addr,
+		Handler:           router,
+		ReadHeaderTimeout: envDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:      envDuration("WRITE_TIMEOUT", 10*time.Second),
+		IdleTimeout:       envDuration("IDLE_TIMEOUT", 60*time.Second),
+		ConnState:         trackConn,
 	}
+	srv.RegisterOnShutdown(stopPprof)
+	srv.RegisterOnShutdown(drainStreams)
 
-	// Open Maxmind database
-	var geoErr error
-	geoDb, geoErr = geoip2.Open(os.Getenv("GEO_FILE"))
-	if geoErr != nil {
-		log.Fatal(geoErr)
+	if err := validateTLS(); err != nil {
+		fatal("invalid TLS configuration", "error", err)
+	}
+
+	// Bind before serving so a failure (e.g. the port is already in use)
+	// stops startup
+	listener, err := listen(srv)
+	if err != nil {
+		fatal("failed to listen", "error", err)
 	}
-	defer geoDb.Close()
 
 	// Start webserver in background to allow for graceful shutdown code below
 	go func() {
-		log.Printf("Listening on port %v...\n", port)
-		if err := srv.ListenAndServe(); err != nil && errors.Is(err, http.ErrServerClosed) {
-			log.Panicln(err.Error())
+		// ErrServerClosed is returned on a clean shutdown; anything else
+		// is a real failure
+		if err := serve(srv, listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("server failed", "error", err)
 		}
 	}()
 
+	// Reload the database from GEO_FILE on SIGHUP so a fresh copy can be
+	// swapped in without a restart
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	onCleanup(func() { signal.Stop(hup) })
+	go func() {
+		for range hup {
+			reloadDatabase()
+		}
+	}()
+
+	if geoRefreshInterval > 0 {
+		if geoURL == "" || dbEmbedded {
+			fatal("GEO_REFRESH_INTERVAL requires GEO_URL")
+		}
+		go refreshLoop(background)
+	}
+
+	if watchDb && dbEmbedded {
+		slog.Warn("the database is embedded, ignoring WATCH_DB")
+	} else if watchDb {
+		watcher, err := watchDatabase()
+		if err != nil {
+			fatal("failed to watch GEO_FILE", "file", geoFile, "error", err)
+		}
+		onCleanup(func() { watcher.Close() })
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
-	// kill (no param) default send syscall.SIGTERM
-	// kill -2 is syscall.SIGINT
-	// kill -9 is syscall.SIGKILL but can't be caught, so don't need to add it
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Println("Shutting down server...")
-
-	// The context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// a timeout of SHUTDOWN_TIMEOUT.
+	<-notifyShutdown()
+	slog.Info("shutting down server")
+
+	if err := shutdownServer(srv); err != nil {
+		fatal("server forced to shutdown", "error", err, "active_connections", openConns.Load())
+	}
+
+	slog.Info("server exiting")
+}
+
+// Creates the router with the middleware and routes of the service, as
+// configured by the environment
+func newRouter() *gin.Engine {
+	router := gin.New()
+	if err := setTrustedProxies(router); err != nil {
+		fatal("invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	if tracingEnabled() {
+		router.Use(otelgin.Middleware(envString("OTEL_SERVICE_NAME", traceServiceName)))
+	}
+	router.Use(requestIDMiddleware)
+	router.Use(metricsMiddleware)
+	router.Use(requestLogger)
+
+	// Recovery middleware recovers from any panics and writes a JSON 500 for
+	// them. It's inside the metrics and logging so the 500 is still counted
+	// and logged.
+	router.Use(recoveryMiddleware())
+
+	if corsHandler := corsMiddleware(); corsHandler != nil {
+		router.Use(corsHandler)
+	}
+
+	if gzipMiddleware := compressionMiddleware(); gzipMiddleware != nil {
+		router.Use(gzipMiddleware)
+	}
+
+	// Liveness probe, OK as long as the process is serving requests
+	router.Match(readMethods, "/healthz", noStore, func(c *gin.Context) {
+		c.String(200, "OK")
+	})
+
+	router.Match(readMethods, "/readyz", noStore, readyHandler)
+	router.GET("/version", noStore, versionHandler)
+	router.GET("/openapi.json", openAPIHandler)
+
+	router.GET("/metrics", noStore, gin.WrapH(promhttp.Handler()))
+	registerPprof(router)
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Panicf("Server forced to shutdown: %s\n", err.Error())
+	// Geo routes are mounted under ROUTE_PREFIX while the probes and
+	// metrics above always stay at the root
+	geo := router.Group(routePrefix)
+	geo.Use(outcomeMiddleware)
+	// Recovered again inside the outcome counter, so a panic is counted as
+	// an error
+	geo.Use(recoveryMiddleware())
+	if limiter := rateLimitMiddleware(); limiter != nil {
+		geo.Use(limiter)
+	}
+	if auth := apiKeyMiddleware(); auth != nil {
+		geo.Use(auth)
+	}
+	if limiter := concurrencyMiddleware(); limiter != nil {
+		geo.Use(limiter)
+	}
+	geo.Use(cacheControlMiddleware)
+	geo.Use(conditionalMiddleware)
+	if sources := sourceMiddleware(); sources != nil {
+		geo.Use(sources)
 	}
 
-	log.Println("Server exiting")
+	geo.Match(readMethods, "/geo", allHandler)
+	geo.Match(readMethods, "/geo/lookup", lookupHandler)
+	geo.Match(readMethods, "/geo/point", pointHandler)
+	geo.Match(readMethods, "/geo/zip", zipHandler)
+	geo.Match(readMethods, "/geo/country", countryHandler)
+	geo.Match(readMethods, "/geo/city", cityHandler)
+	geo.Match(readMethods, "/geo/asn", asnHandler)
+	geo.Match(readMethods, "/geo/anonymous", anonymousHandler)
+	geo.Match(readMethods, "/geo/connection-type", connectionTypeHandler)
+	geo.Match(readMethods, "/geo/domain", domainHandler)
+	geo.Match(readMethods, "/geo/isp", ispHandler)
+	geo.Match(readMethods, "/geo/timezone", timezoneHandler)
+	geo.Match(readMethods, "/geo/metro", metroHandler)
+	geo.Match(readMethods, "/geo/traits", traitsHandler)
+	geo.Match(readMethods, "/geo/accuracy", accuracyHandler)
+	geo.Match(readMethods, "/geo/subdivisions", subdivisionsHandler)
+	geo.Match(readMethods, "/geo/continent", continentHandler)
+	geo.Match(readMethods, "/geo/registered-country", registeredCountryHandler)
+	geo.Match(readMethods, "/geo/represented-country", representedCountryHandler)
+	geo.Match(readMethods, "/geo/flag", flagHandler)
+	geo.Match(readMethods, "/geo/distance", distanceHandler)
+	geo.POST("/geo/batch", batchHandler)
+	geo.POST("/geo/bulk-stats", bulkStatsHandler)
+
+	return router
 }
 
-// Gets the city record for the request context. If successful,
-// returns the `*geoip2.City` record and true. If there's a failure,
-// the request is ended directly and the second parameter returned
-// is false.
-func getCityRecord(c *gin.Context) (*geoip2.City, bool) {
-	ip := net.ParseIP(c.Query("ip"))
+// Context key the IP being looked up is stored under
+const lookupIPKey = "lookup_ip"
+
+// Gets the IP address to look up from the request. This is the `ip` query
+// parameter when given (the base address when it's a CIDR), then the
+// address the `host` parameter resolves to, otherwise the address of the
+// client making the request (see `clientIP`). If it's invalid, the request
+// is ended with a 400 and the second parameter returned is false.
+func getIP(c *gin.Context) (net.IP, bool) {
+	// Checked up front so an invalid one is rejected however the IP is
+	// found, and not only for a literal `ip` or a `host`
+	if _, ok := getIPVersion(c); !ok {
+		return nil, false
+	}
+
+	value, literal := c.GetQuery("ip")
+	ok := literal
+
+	var ip net.IP
+	if ok && strings.Contains(value, "/") {
+		if ip, ok = parseCIDRParam(c, value); !ok {
+			return nil, false
+		}
+	} else if ok {
+		ip = net.ParseIP(value)
+	} else if host, hasHost := c.GetQuery("host"); hasHost {
+		if ip, ok = resolveHost(c, host); !ok {
+			return nil, false
+		}
+	} else {
+		ip = clientIP(c)
+	}
+
 	if ip == nil {
-		c.AbortWithStatus(400)
+		// The raw value is only echoed back as a JSON string
+		c.AbortWithStatusJSON(400, gin.H{
+			"error": "invalid or missing ip parameter",
+			"ip":    value,
+		})
 		return nil, false
 	}
 
-	record, err := geoDb.City(ip)
+	// A host is resolved to the version asked for, while a literal IP has
+	// to already be of it
+	if literal && !checkIPVersion(c, ip) {
+		return nil, false
+	}
+
+	ip = normalizeIP(ip)
+	c.Set(lookupIPKey, ip)
+	return ip, true
+}
+
+// Returns IPv4 addresses, including IPv4-mapped IPv6 ones such as
+// "::ffff:1.2.3.4", in their 4-byte form so the same address is always
+// cached, looked up, and logged the same way
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip.To16()
+}
+
+// Gets the IP that was looked up by `getIP` for the request
+func lookupIP(c *gin.Context) net.IP {
+	ip, _ := c.Get(lookupIPKey)
+	return ip.(net.IP)
+}
+
+// How long a single database lookup may take, 0 disables the timeout
+var lookupTimeout = envDuration("LOOKUP_TIMEOUT", 2*time.Second)
+
+// Returned by `lookupCity` when the IP isn't in the database
+var errNotFound = errors.New("ip not found in database")
+
+// Looks up the city record for an IP address
+func lookupCity(ctx context.Context, ip net.IP) (*cityRecord, error) {
+	ip = normalizeIP(ip)
+	key := ip.String()
+	if record, ok := getCachedCity(key); ok {
+		addSource(ctx, "cache")
+		if record == nil {
+			return nil, errNotFound
+		}
+		countCountry(record)
+		return record, nil
+	}
+
+	generation := currentCacheGeneration()
+	ctx, span := startLookupSpan(ctx, key)
+	start := time.Now()
+	record, err := cityWithTimeout(ctx, ip)
+	if err == nil {
+		addFallbackCountry(ctx, record, ip)
+	}
+	lookupDuration.Observe(time.Since(start).Seconds())
+
+	if err == nil && isEmptyRecord(record) {
+		err = errNotFound
+	}
+	endLookupSpan(span, err)
+
+	if errors.Is(err, errNotFound) {
+		setCachedNotFound(key, generation)
+		return nil, err
+	}
+	if errors.Is(err, errNoDatabase) {
+		databaseUnavailable.Inc()
+		return nil, err
+	}
+	if err != nil {
+		lookupErrors.Inc()
+		return nil, err
+	}
+
+	addSource(ctx, "city")
+	setCachedCity(key, record, generation)
+	countCountry(record)
+	return record, nil
+}
+
+// Looks up the city record in the database, giving up after
+// LOOKUP_TIMEOUT with `context.DeadlineExceeded` so a stuck lookup can't
+// tie up the request
+func cityWithTimeout(ctx context.Context, ip net.IP) (*cityRecord, error) {
+	return withLookupTimeout(ctx, func() (*cityRecord, error) { return cityLookup(ip) })
+}
+
+// Looks up the city record in the current database, swapped out by tests
+// to stub the reader
+var cityLookup = geoDb.City
+
+// Runs a database lookup, giving up after LOOKUP_TIMEOUT with
+// `context.DeadlineExceeded`
+func withLookupTimeout[T any](ctx context.Context, lookup func() (T, error)) (T, error) {
+	if lookupTimeout <= 0 {
+		return lookup()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	// Buffered so the lookup goroutine can always finish and exit, even
+	// after we've stopped waiting for it
+	done := make(chan result, 1)
+	go func() {
+		value, err := lookup()
+		done <- result{value, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Fills in the country of a record that doesn't have one from the country
+// database, along with any of the continent and registered and represented
+// countries that are missing too. It's a no-op when COUNTRY_FILE isn't set.
+func addFallbackCountry(ctx context.Context, record *cityRecord, ip net.IP) {
+	if countryDb == nil || record.Country.IsoCode != "" {
+		return
+	}
+
+	fallback, err := countryDb.Country(ip)
 	if err != nil {
-		log.Fatal(err)
-		c.AbortWithStatus(500)
+		slog.Error("failed to look up fallback country", "ip", ip.String(), "error", err)
+		return
+	}
+
+	if fallback.Country.IsoCode == "" {
+		return
+	}
+	addSource(ctx, "country-fallback")
+
+	record.Country = fallback.Country
+	if record.Continent.Code == "" {
+		record.Continent = fallback.Continent
+	}
+	if record.RegisteredCountry.IsoCode == "" {
+		record.RegisteredCountry = fallback.RegisteredCountry
+	}
+	if record.RepresentedCountry.IsoCode == "" {
+		record.RepresentedCountry = fallback.RepresentedCountry
+	}
+	record.Traits.IsAnonymousProxy = record.Traits.IsAnonymousProxy || fallback.Traits.IsAnonymousProxy
+	record.Traits.IsSatelliteProvider = record.Traits.IsSatelliteProvider || fallback.Traits.IsSatelliteProvider
+}
+
+// Whether the record has no data at all, meaning the IP isn't in the
+// database. Sparse records (e.g. a country but no city) aren't empty.
+func isEmptyRecord(record *cityRecord) bool {
+	return record.Country.IsoCode == "" &&
+		record.RegisteredCountry.IsoCode == "" &&
+		record.RepresentedCountry.IsoCode == "" &&
+		record.Continent.Code == "" &&
+		len(record.City.Names) == 0 &&
+		len(record.Subdivisions) == 0 &&
+		record.Postal.Code == "" &&
+		record.Location.Latitude == 0 &&
+		record.Location.Longitude == 0 &&
+		record.Location.TimeZone == ""
+}
+
+// Gets the city record for the request context. If successful, returns
+// the record and true. If there's a failure, including a private or
+// reserved IP being rejected, the request is ended directly and the
+// second parameter returned is false.
+func getCityRecord(c *gin.Context) (*cityRecord, bool) {
+	ip, ok := getLookupIP(c)
+	if !ok {
 		return nil, false
 	}
 
+	record, err := lookupCity(c.Request.Context(), ip)
+	if err != nil {
+		respondLookupError(c, ip, err)
+		return nil, false
+	}
 	return record, true
 }
 
-// Returns the zip code for the IP address in the request
-func zipHandler(c *gin.Context) {
-	if record, ok := getCityRecord(c); ok {
-		c.JSON(200, gin.H{
-			"zip": record.Postal.Code,
+// Gets the IP address to look up for the request, rejecting private and
+// reserved IPs with a 422. If the IP can't be looked up, the request is
+// ended and the second parameter returned is false.
+func getLookupIP(c *gin.Context) (net.IP, bool) {
+	ip, ok := getIP(c)
+	if !ok {
+		return nil, false
+	}
+
+	if rejectIP(ip) {
+		c.AbortWithStatusJSON(422, gin.H{
+			"error": "private or reserved ip",
+			"ip":    ip.String(),
 		})
+		return nil, false
+	}
+	return ip, true
+}
+
+// Builds the response for the city record of an IP
+type cityResponse func(ip net.IP, record *cityRecord) interface{}
+
+// Whether the `ip` parameter holds a comma-separated list of IPs
+func isMultiIP(c *gin.Context) bool {
+	return strings.Contains(c.Query("ip"), ",")
+}
+
+// Looks up the IP address in the request and writes the response built
+// for its record. When the `ip` parameter is a comma-separated list, every
+// IP is looked up instead and an array is written with the response of each
+// (with its `ip` added) or the error for it, in the same order as the list.
+func respondCity(c *gin.Context, build cityResponse) {
+	if !isMultiIP(c) {
+		if record, ok := getCityRecord(c); ok {
+			render(c, 200, build(lookupIP(c), record))
+		}
+		return
+	}
+
+	version, ok := getIPVersion(c)
+	if !ok {
+		return
+	}
+
+	values := strings.Split(c.Query("ip"), ",")
+	if !checkBatchSize(c, len(values)) {
+		return
+	}
+
+	results := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+
+		var record *cityRecord
+		errMsg := ""
+		if ip := net.ParseIP(value); ip != nil && !matchesIPVersion(ip, version) {
+			errMsg = ipVersionError(version)
+		} else {
+			record, errMsg = lookupIPString(c.Request.Context(), value)
+		}
+		if errMsg != "" {
+			if wantsXML(c) {
+				results = append(results, xmlResult{ip: value, value: gin.H{"error": errMsg}})
+			} else {
+				results = append(results, gin.H{"ip": value, "error": errMsg})
+			}
+			continue
+		}
+
+		// XML carries the IP as an attribute instead of an added key
+		if wantsXML(c) {
+			results = append(results, xmlResult{ip: value, value: build(net.ParseIP(value), record)})
+			continue
+		}
+
+		result, err := withIP(value, build(net.ParseIP(value), record))
+		if err != nil {
+			slog.Error("failed to encode response", "ip", value, "error", err)
+			c.AbortWithStatus(500)
+			return
+		}
+		results = append(results, result)
+	}
+
+	render(c, 200, results)
+}
+
+// Adds the `ip` key to a response object
+func withIP(ip string, response interface{}) (map[string]json.RawMessage, error) {
+	return withField("ip", ip, response)
+}
+
+// Adds a key to a response object
+func withField(key string, value interface{}, response interface{}) (map[string]json.RawMessage, error) {
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		return nil, err
+	}
+
+	if result[key], err = json.Marshal(value); err != nil {
+		return nil, err
 	}
+	return result, nil
 }
 
-// Returns the lat/lon point for the IP address in the request
+// Returns the zip code for the IP address in the request, as bare text
+// with `format=raw`
+func zipHandler(c *gin.Context) {
+	if wantsRawValue(c) {
+		respondRawValue(c, func(record *cityRecord) string { return record.Postal.Code })
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return ZipResponse{Zip: record.Postal.Code}
+	})
+}
+
+// Returns the lat/lon point for the IP address in the request and how
+// accurate it is, or a GeoJSON feature when it's requested. The point is
+// a [lat, lon] array unless `point_format=object` asks for named keys.
 func pointHandler(c *gin.Context) {
-	if record, ok := getCityRecord(c); ok {
-		c.JSON(200, gin.H{
-			"point": []float64{record.Location.Latitude, record.Location.Longitude},
+	pointFormat := c.DefaultQuery("point_format", "array")
+	if pointFormat != "array" && pointFormat != "object" {
+		c.AbortWithStatusJSON(400, gin.H{"error": "point_format must be array or object"})
+		return
+	}
+
+	if wantsGeoJSON(c) {
+		if isMultiIP(c) {
+			c.AbortWithStatusJSON(400, gin.H{"error": "geojson is only supported for a single ip"})
+			return
+		}
+
+		if record, ok := getCityRecord(c); ok {
+			renderGeoJSON(c, newGeoJSONFeature(lookupIP(c).String(), record))
+		}
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		if pointFormat == "object" {
+			return PointObjectResponse{
+				Point:            LatLng{Latitude: record.Location.Latitude, Longitude: record.Location.Longitude},
+				AccuracyRadiusKm: record.Location.AccuracyRadius,
+			}
+		}
+
+		return PointResponse{
+			Point:            []float64{record.Location.Latitude, record.Location.Longitude},
+			AccuracyRadiusKm: record.Location.AccuracyRadius,
+		}
+	})
+}
+
+// Returns the city name and GeoNames ID for the IP address in the request.
+// They're an empty string and 0 when the IP has no city-level data.
+func cityHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"city":       localName(record.City.Names, lang),
+			"geoname_id": record.City.GeoNameID,
+		}
+	})
+}
+
+// Returns the ISO country code, country name, and whether the country is in
+// the EU for the IP address in the request. The code and name are empty
+// strings when the IP has no country data. `format=raw` returns just the
+// code as text.
+func countryHandler(c *gin.Context) {
+	if wantsRawValue(c) {
+		respondRawValue(c, func(record *cityRecord) string { return record.Country.IsoCode })
+		return
+	}
+
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"country_code": record.Country.IsoCode,
+			"country_name": localName(record.Country.Names, lang),
+			"is_eu":        record.Country.IsInEuropeanUnion,
+		}
+	})
+}
+
+// Builds the country of a city record
+func newCountry(record *cityRecord, lang string) Country {
+	return Country{
+		Place: Place{
+			IsoCode: record.Country.IsoCode,
+			Name:    localName(record.Country.Names, lang),
+		},
+		IsEU: record.Country.IsInEuropeanUnion,
+	}
+}
+
+// Builds the administrative hierarchy of a city record from the largest
+// subdivision (e.g. a state) to the smallest. Always non-nil so it's
+// returned as an empty array rather than null.
+func newSubdivisions(record *cityRecord, lang string) []Place {
+	subdivisions := make([]Place, 0, len(record.Subdivisions))
+	for _, sub := range record.Subdivisions {
+		subdivisions = append(subdivisions, Place{
+			IsoCode: sub.IsoCode,
+			Name:    localName(sub.Names, lang),
 		})
 	}
+	return subdivisions
+}
+
+// Builds the combined response for a city record, with names in the given
+// locale
+func newGeoResponse(record *cityRecord, lang string) GeoResponse {
+	return GeoResponse{
+		Country:      newCountry(record, lang),
+		Subdivisions: newSubdivisions(record, lang),
+		City:         localName(record.City.Names, lang),
+		Postal:       record.Postal.Code,
+		Location: Location{
+			Latitude:       record.Location.Latitude,
+			Longitude:      record.Location.Longitude,
+			AccuracyRadius: record.Location.AccuracyRadius,
+		},
+		TimeZone:  record.Location.TimeZone,
+		MetroCode: record.Location.MetroCode,
+	}
+}
+
+// Builds the combined response for the record of a lookup, with the
+// network the IP matched and, for Enterprise databases, the city and
+// postal confidence scores, which are left unset for other databases
+func newLookupGeoResponse(record *cityRecord, lang string) GeoResponse {
+	response := newGeoResponse(record, lang)
+	response.CityConfidence = record.cityConfidence
+	response.PostalConfidence = record.postalConfidence
+	if record.network != nil {
+		response.Network = record.network.String()
+	}
+	return response
+}
+
+// Returns everything known about the IP address in the request from a
+// single lookup, or only the fields listed in the `fields` parameter. With
+// `raw=true`, the record is returned as the geoip2 library decodes it
+// instead, with every field and locale.
+func allHandler(c *gin.Context) {
+	fields, ok := getFields(c)
+	if !ok {
+		return
+	}
+
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	var raw bool
+	if value, given := c.GetQuery("raw"); given {
+		var err error
+		if raw, err = strconv.ParseBool(value); err != nil {
+			c.AbortWithStatusJSON(400, gin.H{"error": "invalid raw parameter"})
+			return
+		}
+	}
+	if raw && fields != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "raw can't be combined with fields"})
+		return
+	}
+	// The record's name maps can't be encoded as XML
+	if raw && wantsXML(c) {
+		c.AbortWithStatusJSON(400, gin.H{"error": "raw is only supported for json"})
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		if raw {
+			return &record.geoRecord
+		}
+		if fields != nil {
+			return selectFields(record, fields, lang)
+		}
+
+		return newLookupGeoResponse(record, lang)
+	})
+}
+
+// Returns the autonomous system number and organization for the IP address
+// in the request. Responds with a 501 when no ASN database is configured.
+func asnHandler(c *gin.Context) {
+	respondOptionalLookup(c, "ASN", asnDb != nil, "asn", func(ip net.IP) (interface{}, error) {
+		record, err := asnDb.ASN(ip)
+		if err != nil {
+			return nil, err
+		}
+		if record.AutonomousSystemNumber == 0 && record.AutonomousSystemOrganization == "" {
+			return nil, errNotFound
+		}
+		return newASNResponse(record), nil
+	})
+}
+
+// Returns whether the IP address in the request is an anonymous source
+// such as a VPN, hosting provider, public proxy or Tor exit node. Responds
+// with a 501 when no Anonymous IP database is configured.
+func anonymousHandler(c *gin.Context) {
+	respondOptionalLookup(c, "Anonymous IP", anonDb != nil, "anonymous", func(ip net.IP) (interface{}, error) {
+		// The database only lists anonymous networks, so an IP that isn't
+		// in it is answered as not anonymous rather than not found
+		record, err := anonDb.AnonymousIP(ip)
+		if err != nil {
+			return nil, err
+		}
+		return newAnonymousResponse(record), nil
+	})
+}
+
+// Returns the US metro (DMA) code for the IP address in the request. It's 0
+// when the metro is unknown or the IP is outside the US.
+func metroHandler(c *gin.Context) {
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{"metro_code": record.Location.MetroCode}
+	})
+}
+
+// Returns a summary of how precise the lookup of the IP address in the
+// request is: the accuracy radius, which of the city, postal code, and
+// location are known, and confidence scores for Enterprise databases
+func accuracyHandler(c *gin.Context) {
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		response := AccuracyResponse{
+			AccuracyRadiusKm: record.Location.AccuracyRadius,
+			HasCity:          record.City.Names["en"] != "" || record.City.GeoNameID != 0,
+			HasPostal:        record.Postal.Code != "",
+			HasLocation:      record.Location.Latitude != 0 || record.Location.Longitude != 0,
+		}
+		response.CityConfidence = record.cityConfidence
+		response.PostalConfidence = record.postalConfidence
+		return response
+	})
+}
+
+// Returns the network traits of the IP address in the request, whether
+// it's an anonymous proxy or a satellite provider. Both are false when
+// the database doesn't flag them.
+func traitsHandler(c *gin.Context) {
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"is_anonymous_proxy":    record.Traits.IsAnonymousProxy,
+			"is_satellite_provider": record.Traits.IsSatelliteProvider,
+		}
+	})
+}
+
+// Returns the connection type of the IP address in the request (e.g.
+// "Cable/DSL" or "Cellular"). Responds with a 501 when no Connection-Type
+// database is configured.
+func connectionTypeHandler(c *gin.Context) {
+	respondOptionalLookup(c, "Connection-Type", connTypeDb != nil, "connection-type", func(ip net.IP) (interface{}, error) {
+		record, err := connTypeDb.ConnectionType(ip)
+		if err != nil {
+			return nil, err
+		}
+		if record.ConnectionType == "" {
+			return nil, errNotFound
+		}
+		return gin.H{"connection_type": record.ConnectionType}, nil
+	})
+}
+
+// Returns the second-level domain associated with the IP address in the
+// request (e.g. "example.com"). Responds with a 501 when no Domain
+// database is configured.
+func domainHandler(c *gin.Context) {
+	respondOptionalLookup(c, "Domain", domainDb != nil, "domain", func(ip net.IP) (interface{}, error) {
+		record, err := domainDb.Domain(ip)
+		if err != nil {
+			return nil, err
+		}
+		if record.Domain == "" {
+			return nil, errNotFound
+		}
+		return gin.H{"domain": record.Domain}, nil
+	})
+}
+
+// Returns the ISP and organization of the IP address in the request along
+// with its autonomous system. Responds with a 501 when no ISP database is
+// configured.
+func ispHandler(c *gin.Context) {
+	respondOptionalLookup(c, "ISP", ispDb != nil, "isp", func(ip net.IP) (interface{}, error) {
+		record, err := ispDb.ISP(ip)
+		if err != nil {
+			return nil, err
+		}
+		if record.ISP == "" && record.Organization == "" && record.AutonomousSystemNumber == 0 {
+			return nil, errNotFound
+		}
+		return newISPResponse(record), nil
+	})
+}
+
+// Returns the IANA time zone for the IP address in the request along with
+// its current UTC offset (e.g. "-04:00"). The offset is an empty string
+// when the zone is unknown or missing from the system's tzdata.
+func timezoneHandler(c *gin.Context) {
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		offset := ""
+		if zone := record.Location.TimeZone; zone != "" {
+			if loc, err := time.LoadLocation(zone); err == nil {
+				offset = time.Now().In(loc).Format("-07:00")
+			}
+		}
+
+		return gin.H{
+			"time_zone":  record.Location.TimeZone,
+			"utc_offset": offset,
+		}
+	})
+}
+
+// Returns the subdivisions (e.g. state then county) of the IP address in
+// the request, largest first
+func subdivisionsHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"subdivisions": newSubdivisions(record, lang),
+		}
+	})
+}
+
+// Returns the continent code (e.g. "NA") and name for the IP address in
+// the request. Both are empty strings when the continent is unknown.
+func continentHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"code": record.Continent.Code,
+			"name": localName(record.Continent.Names, lang),
+		}
+	})
+}
+
+// Returns the country the IP address in the request is registered to by
+// its ISP, which can differ from the country it's located in. The code
+// and name are empty strings when the IP has no registered country data.
+func registeredCountryHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"country_code": record.RegisteredCountry.IsoCode,
+			"country_name": localName(record.RegisteredCountry.Names, lang),
+			"is_eu":        record.RegisteredCountry.IsInEuropeanUnion,
+		}
+	})
+}
+
+// Returns the country represented by the users of the IP address in the
+// request, such as the country of a military base or embassy, along with
+// the type of representation (e.g. "military"). The fields are empty for
+// the IPs that don't represent another country, which is most of them.
+func representedCountryHandler(c *gin.Context) {
+	lang, ok := getLang(c)
+	if !ok {
+		return
+	}
+
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"country_code": record.RepresentedCountry.IsoCode,
+			"country_name": localName(record.RepresentedCountry.Names, lang),
+			"is_eu":        record.RepresentedCountry.IsInEuropeanUnion,
+			"type":         record.RepresentedCountry.Type,
+		}
+	})
+}
+
+// Returns the flag emoji of the country of the IP address in the request
+// along with its ISO code. The flag is empty when the IP has no country.
+func flagHandler(c *gin.Context) {
+	respondCity(c, func(ip net.IP, record *cityRecord) interface{} {
+		return gin.H{
+			"flag":         countryFlag(record.Country.IsoCode),
+			"country_code": record.Country.IsoCode,
+		}
+	})
+}
+
+// Builds the flag emoji of a two-letter ISO country code from the pair of
+// regional indicator symbols for its letters, e.g. "US" is 🇺🇸. Anything else
+// has no flag and gives an empty string.
+func countryFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+
+	flag := make([]rune, 0, 2)
+	for _, letter := range strings.ToUpper(code) {
+		if letter < 'A' || letter > 'Z' {
+			return ""
+		}
+		flag = append(flag, '\U0001F1E6'+letter-'A')
+	}
+	return string(flag)
 }
@@ -0,0 +1,896 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// The GeoLite2 City database of the repository the tests look IPs up in
+const testGeoFile = "GeoLite2-City.mmdb"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+
+	readers, err := openReaders(openTestGeoFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open the test database:", err)
+		os.Exit(1)
+	}
+	geoDb.Swap(readers)
+
+	if err := initCache(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create the cache:", err)
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func openTestGeoFile() (*maxminddb.Reader, error) {
+	return maxminddb.Open(testGeoFile)
+}
+
+// Sets a package setting for the duration of the test
+func setConfig[T any](t testing.TB, setting *T, value T) {
+	t.Helper()
+
+	old := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = old })
+}
+
+// Captures the logs written during the test as JSON lines, with the
+// handler options given
+func captureLogs(t testing.TB, options *slog.HandlerOptions) *bytes.Buffer {
+	t.Helper()
+
+	var logs bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, options)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &logs
+}
+
+// Decodes the captured log lines with the message
+func logLines(t testing.TB, logs *bytes.Buffer, msg string) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if entry["msg"] == msg {
+			lines = append(lines, entry)
+		}
+	}
+	return lines
+}
+
+// Swaps the readers into geoDb for the duration of the test, which takes
+// ownership of them. The repository's database is swapped back in after.
+func useDatabase(t testing.TB, readers ...*maxminddb.Reader) {
+	t.Helper()
+
+	restoreDatabase(t)
+	geoDb.Swap(readers)
+	purgeCache()
+}
+
+// Swaps the repository's database back into geoDb once the test is done
+func restoreDatabase(t testing.TB) {
+	t.Cleanup(func() {
+		restored, err := openReaders(openTestGeoFile)
+		if err != nil {
+			t.Fatalf("failed to reopen the test database: %v", err)
+		}
+		geoDb.Swap(restored)
+		purgeCache()
+	})
+}
+
+// stubResolver resolves every host to its IPs, or fails with its error
+type stubResolver struct {
+	ips []net.IP
+	err error
+}
+
+func (r stubResolver) LookupIP(ctx context.Context, network string, host string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, ip := range r.ips {
+		if matchesIPVersion(ip, strings.TrimPrefix(network, "ip")) {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, r.err
+}
+
+// Resolves every host to the IPs for the duration of the test
+func stubHosts(t testing.TB, ips ...string) {
+	t.Helper()
+
+	var resolver stubResolver
+	for _, ip := range ips {
+		resolver.ips = append(resolver.ips, net.ParseIP(ip))
+	}
+	useResolver(t, resolver)
+}
+
+// Resolves hosts with the stub for the duration of the test
+func useResolver(t testing.TB, resolver stubResolver) {
+	old := hostResolver
+	hostResolver = resolver
+	t.Cleanup(func() { hostResolver = old })
+}
+
+// Serves the request with a newly created router, so it picks up the
+// settings of the test
+func serveRequest(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	newRouter().ServeHTTP(w, req)
+	return w
+}
+
+// Serves a GET of the target
+func get(target string) *httptest.ResponseRecorder {
+	return serveRequest(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+// Serves a POST of the body to the target
+func post(target string, contentType string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return serveRequest(req)
+}
+
+// Checks the status of the response and decodes its JSON body
+func decodeResponse(t testing.TB, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("got status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestCountryHandler(t *testing.T) {
+	var body struct {
+		CountryCode string `json:"country_code"`
+		CountryName string `json:"country_name"`
+	}
+	decodeResponse(t, get("/geo/country?ip=81.2.69.142"), 200, &body)
+
+	if body.CountryCode != "GB" || body.CountryName != "United Kingdom" {
+		t.Errorf("got %+v, want GB United Kingdom", body)
+	}
+}
+
+func TestLookupErrorReturns500(t *testing.T) {
+	// A record that isn't a map can't be decoded into a city record
+	useDatabase(t, openTestDatabase(t, "GeoIP2-City", time.Now(),
+		testNetwork{"81.2.69.0/24", "not a record"},
+		testNetwork{"216.160.83.0/24", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "US"},
+		}},
+	))
+
+	if w := get("/geo/country?ip=81.2.69.142"); w.Code != 500 {
+		t.Fatalf("got status %d for a failed lookup, want 500", w.Code)
+	}
+
+	// The failure only ends its own request
+	var body struct {
+		CountryCode string `json:"country_code"`
+	}
+	decodeResponse(t, get("/geo/country?ip=216.160.83.56"), 200, &body)
+	if body.CountryCode != "US" {
+		t.Errorf("got country %q after a failed lookup, want US", body.CountryCode)
+	}
+}
+
+func TestTimezoneHandler(t *testing.T) {
+	var body struct {
+		TimeZone  string `json:"time_zone"`
+		UTCOffset string `json:"utc_offset"`
+	}
+	decodeResponse(t, get("/geo/timezone?ip=2.56.9.245"), 200, &body)
+
+	if body.TimeZone != "America/New_York" {
+		t.Errorf("got time zone %q, want America/New_York", body.TimeZone)
+	}
+	if body.UTCOffset != "-04:00" && body.UTCOffset != "-05:00" {
+		t.Errorf("got UTC offset %q for New York", body.UTCOffset)
+	}
+}
+
+func TestLookupStatus(t *testing.T) {
+	tests := []struct {
+		ip     string
+		status int
+	}{
+		{"81.2.69.142", 200},
+		{"3000::1", 404},
+		{"0.1.2.3", 404},
+		{"10.0.0.1", 422},
+		{"127.0.0.1", 422},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			var body map[string]interface{}
+			decodeResponse(t, get("/geo/zip?ip="+tt.ip), tt.status, &body)
+			if tt.status != 200 && body["error"] == nil {
+				t.Errorf("got no error in %v", body)
+			}
+		})
+	}
+}
+
+func TestRoutePrefix(t *testing.T) {
+	setConfig(t, &routePrefix, "/api/v1")
+
+	var body ZipResponse
+	decodeResponse(t, get("/api/v1/geo/zip?ip=81.2.69.142"), 200, &body)
+	if body.Zip != "NR1" {
+		t.Errorf("got zip %q, want NR1", body.Zip)
+	}
+
+	if w := get("/geo/zip?ip=81.2.69.142"); w.Code != 404 {
+		t.Errorf("got status %d without the prefix, want 404", w.Code)
+	}
+
+	// The probes stay at the root
+	if w := get("/healthz"); w.Code != 200 {
+		t.Errorf("got status %d for /healthz, want 200", w.Code)
+	}
+}
+
+func TestInvalidIPParameter(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"empty", "/geo/zip?ip=", `{"error":"invalid or missing ip parameter","ip":""}`},
+		{"malformed", "/geo/zip?ip=bogus", `{"error":"invalid or missing ip parameter","ip":"bogus"}`},
+		{"out of range", "/geo/zip?ip=256.1.1.1", `{"error":"invalid or missing ip parameter","ip":"256.1.1.1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(tt.target)
+			if w.Code != 400 || w.Body.String() != tt.want {
+				t.Errorf("got %d %s, want 400 %s", w.Code, w.Body.String(), tt.want)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+				t.Errorf("got Content-Type %q, want JSON", got)
+			}
+		})
+	}
+}
+
+func TestSubdivisionsHandler(t *testing.T) {
+	var body struct {
+		Subdivisions []Place `json:"subdivisions"`
+	}
+	decodeResponse(t, get("/geo/subdivisions?ip=216.160.83.56"), 200, &body)
+
+	if len(body.Subdivisions) == 0 || body.Subdivisions[0].IsoCode != "WA" {
+		t.Errorf("got subdivisions %+v, want Washington first", body.Subdivisions)
+	}
+}
+
+func TestMultipleIPs(t *testing.T) {
+	var results []struct {
+		IP    string `json:"ip"`
+		Zip   string `json:"zip"`
+		Error string `json:"error"`
+	}
+	decodeResponse(t, get("/geo/zip?ip=81.2.69.142,%20216.160.83.56,10.0.0.1"), 200, &results)
+
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if results[0].IP != "81.2.69.142" || results[0].Zip != "NR1" {
+		t.Errorf("got %+v first, want 81.2.69.142 in NR1", results[0])
+	}
+	if results[1].IP != "216.160.83.56" || results[1].Zip != "98370" {
+		t.Errorf("got %+v second, want 216.160.83.56 in 98370", results[1])
+	}
+	if results[2].IP != "10.0.0.1" || results[2].Error != "private or reserved ip" {
+		t.Errorf("got %+v third, want an error for 10.0.0.1", results[2])
+	}
+}
+
+func TestSingleIP(t *testing.T) {
+	var body ZipResponse
+	decodeResponse(t, get("/geo/zip?ip=81.2.69.142"), 200, &body)
+
+	if body.Zip != "NR1" {
+		t.Errorf("got zip %q, want NR1", body.Zip)
+	}
+}
+
+func TestLookupTimeout(t *testing.T) {
+	setConfig(t, &lookupTimeout, 20*time.Millisecond)
+	done := make(chan struct{})
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		defer close(done)
+		time.Sleep(200 * time.Millisecond)
+		return geoDb.City(ip)
+	})
+	purgeCache()
+
+	w := get("/geo/zip?ip=81.2.69.142")
+	if w.Code != 504 || w.Body.String() != `{"error":"lookup timed out"}` {
+		t.Errorf("got %d %s for a slow lookup, want a 504", w.Code, w.Body.String())
+	}
+
+	// The abandoned lookup still finishes in the background
+	<-done
+}
+
+func TestLookupTimeoutDisabled(t *testing.T) {
+	setConfig(t, &lookupTimeout, 0)
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		time.Sleep(50 * time.Millisecond)
+		return geoDb.City(ip)
+	})
+	purgeCache()
+
+	if w := get("/geo/zip?ip=81.2.69.142"); w.Code != 200 {
+		t.Errorf("got status %d with LOOKUP_TIMEOUT=0, want 200", w.Code)
+	}
+}
+
+func TestContinentHandler(t *testing.T) {
+	var body struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+	}
+	decodeResponse(t, get("/geo/continent?ip=216.160.83.56"), 200, &body)
+
+	if body.Code != "NA" || body.Name != "North America" {
+		t.Errorf("got %+v, want NA North America", body)
+	}
+}
+
+func TestCountryIsEU(t *testing.T) {
+	tests := []struct {
+		ip   string
+		isEU bool
+	}{
+		{"89.160.20.112", true},
+		{"81.2.69.142", false},
+		{"216.160.83.56", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			var body struct {
+				IsEU *bool `json:"is_eu"`
+			}
+			decodeResponse(t, get("/geo/country?ip="+tt.ip), 200, &body)
+			if body.IsEU == nil || *body.IsEU != tt.isEU {
+				t.Errorf("got is_eu %v, want %t", body.IsEU, tt.isEU)
+			}
+		})
+	}
+}
+
+func TestPointAccuracyRadius(t *testing.T) {
+	var body map[string]interface{}
+	decodeResponse(t, get("/geo/point?ip=81.2.69.142"), 200, &body)
+
+	radius, ok := body["accuracy_radius_km"].(float64)
+	if !ok || radius != 200 {
+		t.Errorf("got accuracy_radius_km %v, want 200", body["accuracy_radius_km"])
+	}
+}
+
+func TestAnonymousHandler(t *testing.T) {
+	setConfig(t, &anonDb, openTestGeoIP2(t, "GeoIP2-Anonymous-IP", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"is_anonymous":     true,
+		"is_anonymous_vpn": true,
+	}}))
+
+	var body AnonymousResponse
+	decodeResponse(t, get("/geo/anonymous?ip=81.2.69.142"), 200, &body)
+	if want := (AnonymousResponse{IsAnonymous: true, IsAnonymousVPN: true}); body != want {
+		t.Errorf("got %+v for a VPN, want %+v", body, want)
+	}
+
+	// Only anonymous networks are listed, so others aren't anonymous
+	decodeResponse(t, get("/geo/anonymous?ip=216.160.83.56"), 200, &body)
+	if body != (AnonymousResponse{}) {
+		t.Errorf("got %+v for an unlisted IP, want it not anonymous", body)
+	}
+
+	if w := get("/geo/anonymous?ip=10.0.0.1"); w.Code != 422 {
+		t.Errorf("got status %d for a private IP, want 422", w.Code)
+	}
+}
+
+func TestAnonymousHandlerNotConfigured(t *testing.T) {
+	setConfig(t, &anonDb, nil)
+
+	if w := get("/geo/anonymous?ip=81.2.69.142"); w.Code != 501 {
+		t.Errorf("got status %d without ANON_FILE, want 501", w.Code)
+	}
+}
+
+func TestRegisteredCountryHandler(t *testing.T) {
+	var registered, country struct {
+		CountryCode string `json:"country_code"`
+	}
+
+	// An IP located in Russia on a network registered in China
+	decodeResponse(t, get("/geo/registered-country?ip=1.2.9.0"), 200, &registered)
+	decodeResponse(t, get("/geo/country?ip=1.2.9.0"), 200, &country)
+
+	if registered.CountryCode != "CN" || country.CountryCode != "RU" {
+		t.Errorf("got registered country %q and country %q, want CN and RU", registered.CountryCode, country.CountryCode)
+	}
+}
+
+func TestMetroHandler(t *testing.T) {
+	var body struct {
+		MetroCode uint `json:"metro_code"`
+	}
+
+	// New York
+	decodeResponse(t, get("/geo/metro?ip=2.56.9.245"), 200, &body)
+	if body.MetroCode != 501 {
+		t.Errorf("got metro code %d for New York, want 501", body.MetroCode)
+	}
+
+	decodeResponse(t, get("/geo?ip=2.56.9.245"), 200, &body)
+	if body.MetroCode != 501 {
+		t.Errorf("got metro code %d in the full record for New York, want 501", body.MetroCode)
+	}
+
+	decodeResponse(t, get("/geo/metro?ip=81.2.69.142"), 200, &body)
+	if body.MetroCode != 0 {
+		t.Errorf("got metro code %d outside the US, want 0", body.MetroCode)
+	}
+}
+
+func TestCityHandler(t *testing.T) {
+	var body struct {
+		City      string `json:"city"`
+		GeoNameID uint   `json:"geoname_id"`
+	}
+	decodeResponse(t, get("/geo/city?ip=81.2.69.142"), 200, &body)
+
+	if body.City != "Norwich" || body.GeoNameID != 2641181 {
+		t.Errorf("got %+v, want Norwich 2641181", body)
+	}
+}
+
+func TestResponseTypes(t *testing.T) {
+	tests := []struct {
+		target string
+		into   interface{}
+		want   interface{}
+	}{
+		{"/geo/point?ip=81.2.69.142", &PointResponse{}, &PointResponse{Point: []float64{52.6259, 1.3032}, AccuracyRadiusKm: 200}},
+		{"/geo/zip?ip=81.2.69.142", &ZipResponse{}, &ZipResponse{Zip: "NR1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := get(tt.target)
+			if w.Code != 200 {
+				t.Fatalf("got status %d, want 200", w.Code)
+			}
+
+			// Every key of the response is a field of its type
+			decoder := json.NewDecoder(w.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(tt.into); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.into, tt.want) {
+				t.Errorf("got %+v, want %+v", tt.into, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraitsHandler(t *testing.T) {
+	var body map[string]interface{}
+	decodeResponse(t, get("/geo/traits?ip=81.2.69.142"), 200, &body)
+
+	for _, key := range []string{"is_anonymous_proxy", "is_satellite_provider"} {
+		if _, ok := body[key].(bool); !ok {
+			t.Errorf("got %v for %s, want a bool", body[key], key)
+		}
+	}
+}
+
+// Opens a test Enterprise database with confidence scores for 81.2.69.0/24
+func openTestEnterpriseDatabase(t testing.TB) *maxminddb.Reader {
+	t.Helper()
+
+	reader, err := maxminddb.FromBytes(buildTestDatabase(t, "GeoIP2-Enterprise", time.Now(), testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "GB"},
+		"city":    map[string]interface{}{"confidence": uint16(60), "geoname_id": uint32(2641181), "names": map[string]interface{}{"en": "Norwich"}},
+		"postal":  map[string]interface{}{"confidence": uint16(20), "code": "NR1"},
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reader
+}
+
+func TestPostalConfidence(t *testing.T) {
+	useDatabase(t, openTestEnterpriseDatabase(t))
+
+	var body GeoResponse
+	decodeResponse(t, get("/geo?ip=81.2.69.142"), 200, &body)
+	if body.PostalConfidence == nil || *body.PostalConfidence != 20 {
+		t.Errorf("got postal confidence %v from an Enterprise database, want 20", body.PostalConfidence)
+	}
+	if body.CityConfidence == nil || *body.CityConfidence != 60 {
+		t.Errorf("got city confidence %v from an Enterprise database, want 60", body.CityConfidence)
+	}
+}
+
+func TestPostalConfidenceGeoLite2(t *testing.T) {
+	var body map[string]interface{}
+	decodeResponse(t, get("/geo?ip=81.2.69.142"), 200, &body)
+
+	for _, key := range []string{"postal_confidence", "city_confidence"} {
+		if value, ok := body[key]; ok {
+			t.Errorf("got %s %v from a GeoLite2 database, want it omitted", key, value)
+		}
+	}
+}
+
+func TestDatabaseNotLoaded(t *testing.T) {
+	useDatabase(t)
+
+	for _, target := range []string{"/geo/zip?ip=81.2.69.142", "/geo?ip=81.2.69.142", "/geo/lookup?ip=81.2.69.142"} {
+		var body map[string]string
+		decodeResponse(t, get(target), 503, &body)
+		if body["error"] != "database not loaded" {
+			t.Errorf("got %v for %s without a database", body, target)
+		}
+	}
+
+	var results []BatchResult
+	decodeResponse(t, post("/geo/batch", "application/json", `{"ips": ["81.2.69.142"]}`), 200, &results)
+	if len(results) != 1 || results[0].Error != "database not loaded" {
+		t.Errorf("got %+v for a batch without a database", results)
+	}
+}
+
+func TestConnectionTypeHandler(t *testing.T) {
+	setConfig(t, &connTypeDb, openTestGeoIP2(t, "GeoIP2-Connection-Type", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"connection_type": "Cellular",
+	}}))
+
+	var body map[string]string
+	decodeResponse(t, get("/geo/connection-type?ip=81.2.69.142"), 200, &body)
+	if body["connection_type"] != "Cellular" {
+		t.Errorf("got %v, want a Cellular connection", body)
+	}
+
+	if w := get("/geo/connection-type?ip=216.160.83.56"); w.Code != 404 {
+		t.Errorf("got status %d for an unlisted IP, want 404", w.Code)
+	}
+
+	setConfig(t, &connTypeDb, nil)
+	if w := get("/geo/connection-type?ip=81.2.69.142"); w.Code != 501 {
+		t.Errorf("got status %d without CONNTYPE_FILE, want 501", w.Code)
+	}
+}
+
+func TestDomainHandler(t *testing.T) {
+	setConfig(t, &domainDb, openTestGeoIP2(t, "GeoIP2-Domain", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"domain": "aa.net.uk",
+	}}))
+
+	var body map[string]string
+	decodeResponse(t, get("/geo/domain?ip=81.2.69.142"), 200, &body)
+	if body["domain"] != "aa.net.uk" {
+		t.Errorf("got %v, want the domain aa.net.uk", body)
+	}
+
+	if w := get("/geo/domain?ip=216.160.83.56"); w.Code != 404 {
+		t.Errorf("got status %d for an unlisted IP, want 404", w.Code)
+	}
+
+	setConfig(t, &domainDb, nil)
+	if w := get("/geo/domain?ip=81.2.69.142"); w.Code != 501 {
+		t.Errorf("got status %d without DOMAIN_FILE, want 501", w.Code)
+	}
+}
+
+func TestOwnIP(t *testing.T) {
+	setConfig(t, &trustedProxies, mustParseCIDRs("10.0.0.0/8"))
+
+	// Without an ip parameter, the client's own address is looked up
+	req := httptest.NewRequest("GET", "/geo/zip", nil)
+	req.RemoteAddr = "81.2.69.142:1234"
+	if w := serveRequest(req); w.Code != 200 || w.Body.String() != `{"zip":"NR1"}` {
+		t.Errorf("got %d %s for the peer's own address, want NR1", w.Code, w.Body.String())
+	}
+
+	// Along with the address forwarded by a trusted proxy
+	req = httptest.NewRequest("GET", "/geo/zip", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "216.160.83.56")
+	if w := serveRequest(req); w.Code != 200 || w.Body.String() != `{"zip":"98370"}` {
+		t.Errorf("got %d %s for the forwarded address, want 98370", w.Code, w.Body.String())
+	}
+
+	// An ip parameter that's present but empty is still invalid
+	req = httptest.NewRequest("GET", "/geo/zip?ip=", nil)
+	req.RemoteAddr = "81.2.69.142:1234"
+	if w := serveRequest(req); w.Code != 400 {
+		t.Errorf("got status %d for an empty ip parameter, want 400", w.Code)
+	}
+}
+
+func TestAccuracyHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want AccuracyResponse
+	}{
+		{"rich", "81.2.69.142", AccuracyResponse{AccuracyRadiusKm: 200, HasCity: true, HasPostal: true, HasLocation: true}},
+		{"sparse", "1.0.0.1", AccuracyResponse{AccuracyRadiusKm: 1000, HasLocation: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body AccuracyResponse
+			decodeResponse(t, get("/geo/accuracy?ip="+tt.ip), 200, &body)
+			if body != tt.want {
+				t.Errorf("got %+v, want %+v", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccuracyHandlerConfidence(t *testing.T) {
+	useDatabase(t, openTestEnterpriseDatabase(t))
+
+	var body AccuracyResponse
+	decodeResponse(t, get("/geo/accuracy?ip=81.2.69.142"), 200, &body)
+	if body.CityConfidence == nil || *body.CityConfidence != 60 || body.PostalConfidence == nil || *body.PostalConfidence != 20 {
+		t.Errorf("got confidence %v and %v from an Enterprise database, want 60 and 20", body.CityConfidence, body.PostalConfidence)
+	}
+}
+
+func TestNetworkField(t *testing.T) {
+	for _, ip := range []string{"81.2.69.142", "216.160.83.56", "1.0.0.1", "2001:218::1", "::ffff:81.2.69.142"} {
+		t.Run(ip, func(t *testing.T) {
+			var body GeoResponse
+			decodeResponse(t, get("/geo?ip="+ip), 200, &body)
+
+			_, network, err := net.ParseCIDR(body.Network)
+			if err != nil {
+				t.Fatalf("got network %q, want a CIDR: %v", body.Network, err)
+			}
+			if network.String() != body.Network {
+				t.Errorf("got network %q, want it normalized to %s", body.Network, network)
+			}
+			if !network.Contains(net.ParseIP(ip)) {
+				t.Errorf("got network %s, which doesn't contain %s", network, ip)
+			}
+		})
+	}
+}
+
+func TestRawRecord(t *testing.T) {
+	var body geoip2.City
+	decodeResponse(t, get("/geo?ip=81.2.69.142&raw=true"), 200, &body)
+
+	want := map[string]string{
+		"de": "Norwich", "en": "Norwich", "fr": "Norwich",
+		"ja": "ノリッチ", "ru": "Норидж", "zh-CN": "諾里奇",
+	}
+	if !maps.Equal(body.City.Names, want) {
+		t.Errorf("got city names %v, want every language %v", body.City.Names, want)
+	}
+	if len(body.Country.Names) != 8 || body.Country.Names["pt-BR"] != "Reino Unido" {
+		t.Errorf("got country names %v, want all 8 languages", body.Country.Names)
+	}
+	if body.Location.AccuracyRadius != 200 || body.Postal.Code != "NR1" {
+		t.Errorf("got %+v, want the rest of the record as decoded", body)
+	}
+}
+
+func TestISPHandler(t *testing.T) {
+	setConfig(t, &ispDb, openTestGeoIP2(t, "GeoIP2-ISP", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"isp":                            "Andrews & Arnold Ltd",
+		"organization":                   "STONEHOUSE office network",
+		"autonomous_system_number":       uint32(20712),
+		"autonomous_system_organization": "Andrews & Arnold Ltd",
+	}}))
+
+	var body ISPResponse
+	decodeResponse(t, get("/geo/isp?ip=81.2.69.142"), 200, &body)
+	want := ISPResponse{
+		ISP:                          "Andrews & Arnold Ltd",
+		Organization:                 "STONEHOUSE office network",
+		AutonomousSystemNumber:       20712,
+		AutonomousSystemOrganization: "Andrews & Arnold Ltd",
+	}
+	if body != want {
+		t.Errorf("got %+v, want %+v", body, want)
+	}
+
+	if w := get("/geo/isp?ip=216.160.83.56"); w.Code != 404 {
+		t.Errorf("got status %d for an unlisted IP, want 404", w.Code)
+	}
+
+	setConfig(t, &ispDb, nil)
+	if w := get("/geo/isp?ip=81.2.69.142"); w.Code != 501 {
+		t.Errorf("got status %d without ISP_FILE, want 501", w.Code)
+	}
+}
+
+func TestRepresentedCountryHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		// Most IPs aren't of a represented country, which still has every field
+		{"ordinary", "81.2.69.142", `{"country_code":"","country_name":"","is_eu":false,"type":""}`},
+		{"military", "4.53.1.0", `{"country_code":"US","country_name":"United States","is_eu":false,"type":"military"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := get("/geo/represented-country?ip=" + tt.ip); w.Code != 200 || w.Body.String() != tt.want {
+				t.Errorf("got %d %s, want 200 %s", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPointFormat(t *testing.T) {
+	for _, ip := range []string{"81.2.69.142", "216.160.83.56", "2001:218::1"} {
+		t.Run(ip, func(t *testing.T) {
+			var array struct {
+				Point [2]float64 `json:"point"`
+			}
+			var object struct {
+				Point struct {
+					Latitude  float64 `json:"latitude"`
+					Longitude float64 `json:"longitude"`
+				} `json:"point"`
+			}
+			decodeResponse(t, get("/geo/point?ip="+ip), 200, &array)
+			decodeResponse(t, get("/geo/point?ip="+ip+"&point_format=object"), 200, &object)
+
+			if array.Point != [2]float64{object.Point.Latitude, object.Point.Longitude} || array.Point[0] == 0 {
+				t.Errorf("got point %v as an array and %+v as an object, want the same coordinates", array.Point, object.Point)
+			}
+		})
+	}
+
+	if w := get("/geo/point?ip=81.2.69.142&point_format=bogus"); w.Code != 400 {
+		t.Errorf("got status %d for an unknown point_format, want 400", w.Code)
+	}
+}
+
+func TestCountryFallback(t *testing.T) {
+	setConfig(t, &cityCache, nil)
+	setConfig(t, &countryDb, openTestGeoIP2(t, "GeoLite2-Country",
+		testNetwork{"3000::/16", map[string]interface{}{
+			"continent": map[string]interface{}{"code": "EU", "names": map[string]interface{}{"en": "Europe"}},
+			"country": map[string]interface{}{
+				"iso_code":             "FR",
+				"names":                map[string]interface{}{"en": "France"},
+				"is_in_european_union": true,
+			},
+		}},
+		testNetwork{"81.2.69.0/24", map[string]interface{}{
+			"country": map[string]interface{}{"iso_code": "FR"},
+		}},
+	))
+
+	// The city database doesn't have the IP, but the country database does
+	var body struct {
+		CountryCode string `json:"country_code"`
+		CountryName string `json:"country_name"`
+		IsEU        bool   `json:"is_eu"`
+	}
+	decodeResponse(t, get("/geo/country?ip=3000::1"), 200, &body)
+	if body.CountryCode != "FR" || body.CountryName != "France" || !body.IsEU {
+		t.Errorf("got %+v from the country database, want France", body)
+	}
+
+	var continent struct {
+		Code string `json:"code"`
+	}
+	decodeResponse(t, get("/geo/continent?ip=3000::1"), 200, &continent)
+	if continent.Code != "EU" {
+		t.Errorf("got continent %+v from the country database, want EU", continent)
+	}
+
+	// The city database's country is kept when it has one
+	decodeResponse(t, get("/geo/country?ip=81.2.69.142"), 200, &body)
+	if body.CountryCode != "GB" {
+		t.Errorf("got country %q, want GB from the city database", body.CountryCode)
+	}
+
+	setConfig(t, &countryDb, nil)
+	if w := get("/geo/country?ip=3000::1"); w.Code != 404 {
+		t.Errorf("got status %d without COUNTRY_FILE, want 404", w.Code)
+	}
+}
+
+func TestCountryFlag(t *testing.T) {
+	for code, want := range map[string]string{
+		"GB":  "🇬🇧",
+		"US":  "🇺🇸",
+		"jp":  "🇯🇵",
+		"":    "",
+		"G":   "",
+		"GBR": "",
+		"G1":  "",
+	} {
+		if got := countryFlag(code); got != want {
+			t.Errorf("got flag %q for %q, want %q", got, code, want)
+		}
+	}
+}
+
+func TestFlagHandler(t *testing.T) {
+	if w := get("/geo/flag?ip=81.2.69.142"); w.Code != 200 || w.Body.String() != `{"country_code":"GB","flag":"🇬🇧"}` {
+		t.Errorf("got %d %s, want the flag of GB", w.Code, w.Body.String())
+	}
+
+	// A record without a country has no flag
+	useDatabase(t, openTestDatabase(t, "GeoIP2-City", time.Now(),
+		testNetwork{"81.2.69.0/24", map[string]interface{}{
+			"location": map[string]interface{}{"latitude": 52.6259, "longitude": 1.3032},
+		}},
+	))
+	if w := get("/geo/flag?ip=81.2.69.142"); w.Code != 200 || w.Body.String() != `{"country_code":"","flag":""}` {
+		t.Errorf("got %d %s without a country, want an empty flag", w.Code, w.Body.String())
+	}
+}
+
+func TestRawRecordInvalid(t *testing.T) {
+	for _, value := range []string{"yes", "1x", ""} {
+		w := get("/geo?ip=81.2.69.142&raw=" + value)
+		if w.Code != 400 || w.Body.String() != `{"error":"invalid raw parameter"}` {
+			t.Errorf("got %d %s for raw=%s, want a 400", w.Code, w.Body.String(), value)
+		}
+	}
+
+	// The usual spellings of false still give the curated response
+	var body GeoResponse
+	decodeResponse(t, get("/geo?ip=81.2.69.142&raw=false"), 200, &body)
+	if body.City != "Norwich" {
+		t.Errorf("got %+v for raw=false, want the curated response", body)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_http_requests_total",
+		Help: "HTTP requests handled, by route and status code.",
+	}, []string{"path", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "geoip_http_request_duration_seconds",
+		Help:    "Time spent handling HTTP requests, by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path"})
+
+	lookupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "geoip_lookup_duration_seconds",
+		Help:    "Time spent looking up an IP in the GeoIP database.",
+		Buckets: []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01},
+	})
+
+	lookupErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_lookup_errors_total",
+		Help: "GeoIP database lookups that returned an error.",
+	})
+
+	lookupOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_lookup_outcomes_total",
+		Help: "Requests to the geo routes, by outcome.",
+	}, []string{"outcome"})
+
+	countryLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_lookups_by_country_total",
+		Help: "Successful city lookups, by ISO country code.",
+	}, []string{"country"})
+
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_cache_hits_total",
+		Help: "City lookups served from the in-memory cache.",
+	})
+
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_cache_misses_total",
+		Help: "City lookups not found in the in-memory cache.",
+	})
+
+	databaseUnavailable = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_database_unavailable_total",
+		Help: "Lookups attempted while no GeoIP database was loaded.",
+	})
+
+	refreshFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_database_refresh_failures_total",
+		Help: "Background downloads of GEO_URL that failed.",
+	})
+
+	databaseAgeSeconds = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "geoip_database_age_seconds",
+		Help: "Time since the loaded GeoIP database was built.",
+	}, func() float64 {
+		age, _ := databaseAge()
+		return age.Seconds()
+	})
+)
+
+// Records the route, status, and duration of every request
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	// Unmatched requests share a label so random paths can't blow up
+	// the metric cardinality
+	path := c.FullPath()
+	if path == "" {
+		path = "unmatched"
+	}
+
+	requestsTotal.WithLabelValues(path, strconv.Itoa(c.Writer.Status())).Inc()
+	requestDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+}
+
+// Counts the outcome of every request to the geo routes, telling invalid
+// input from the client apart from failures of the service:
+//
+//   - success: 2xx, or a 304 for a conditional request
+//   - invalid: 400, a malformed ip or other parameter
+//   - not_found: 404, the ip isn't in the database
+//   - reserved: 422, a private or reserved ip
+//   - error: 5xx, the lookup failed or no database is loaded
+//   - other: anything else, e.g. a 401 or 429
+func outcomeMiddleware(c *gin.Context) {
+	c.Next()
+
+	status := c.Writer.Status()
+	outcome := "other"
+	switch {
+	case status < 300, status == 304:
+		outcome = "success"
+	case status == 400:
+		outcome = "invalid"
+	case status == 404:
+		outcome = "not_found"
+	case status == 422:
+		outcome = "reserved"
+	case status >= 500:
+		outcome = "error"
+	}
+	lookupOutcomes.WithLabelValues(outcome).Inc()
+}
+
+// Counts a successful lookup under its country. Country codes are a
+// bounded set, so they're safe to use as a label, and records without one
+// aren't counted.
+func countCountry(record *cityRecord) {
+	if code := record.Country.IsoCode; code != "" {
+		countryLookups.WithLabelValues(code).Inc()
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCountryLookupsMetric(t *testing.T) {
+	gb := testutil.ToFloat64(countryLookups.WithLabelValues("GB"))
+	us := testutil.ToFloat64(countryLookups.WithLabelValues("US"))
+
+	for _, target := range []string{
+		"/geo/country?ip=81.2.69.142",
+		"/geo/country?ip=81.2.69.142",
+		"/geo/country?ip=216.160.83.56",
+		"/geo/country?ip=3000::1",
+	} {
+		get(target)
+	}
+
+	if got := testutil.ToFloat64(countryLookups.WithLabelValues("GB")) - gb; got != 2 {
+		t.Errorf("counted %v lookups for GB, want 2", got)
+	}
+	if got := testutil.ToFloat64(countryLookups.WithLabelValues("US")) - us; got != 1 {
+		t.Errorf("counted %v lookups for US, want 1", got)
+	}
+}
+
+func TestLookupOutcomesMetric(t *testing.T) {
+	setConfig(t, &cityCache, nil)
+
+	tests := []struct {
+		target  string
+		outcome string
+	}{
+		{"/geo/country?ip=81.2.69.142", "success"},
+		{"/geo/country?ip=bogus", "invalid"},
+		{"/geo/country?ip=3000::1", "not_found"},
+		{"/geo/country?ip=10.0.0.1", "reserved"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.outcome, func(t *testing.T) {
+			before := testutil.ToFloat64(lookupOutcomes.WithLabelValues(tt.outcome))
+			get(tt.target)
+			if got := testutil.ToFloat64(lookupOutcomes.WithLabelValues(tt.outcome)) - before; got != 1 {
+				t.Errorf("counted %v %s outcomes for %s, want 1", got, tt.outcome, tt.target)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+			return nil, errors.New("corrupt database")
+		})
+
+		before := testutil.ToFloat64(lookupOutcomes.WithLabelValues("error"))
+		if w := get("/geo/country?ip=81.2.69.142"); w.Code != 500 {
+			t.Fatalf("got status %d for a failed lookup, want 500", w.Code)
+		}
+		if got := testutil.ToFloat64(lookupOutcomes.WithLabelValues("error")) - before; got != 1 {
+			t.Errorf("counted %v error outcomes for a failed lookup, want 1", got)
+		}
+	})
+}
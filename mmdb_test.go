@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// testNetwork is a network of a test database and the record it maps to
+type testNetwork struct {
+	cidr   string
+	record interface{}
+}
+
+// testNode is a node of the search tree of a test database. Each side
+// either points to another node, to a record, or to nothing.
+type testNode struct {
+	children [2]*testNode
+	records  [2]*int
+	index    int
+}
+
+// Builds a MaxMind DB in memory with the given networks, for the databases
+// there's no fixture of in the repository. IPv4 networks are mapped into
+// the IPv4 subtree of the IPv6 tree, as in MaxMind's own databases.
+func buildTestDatabase(t testing.TB, databaseType string, built time.Time, networks ...testNetwork) []byte {
+	t.Helper()
+
+	root := &testNode{}
+	var data bytes.Buffer
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatalf("invalid test network %q: %v", network.cidr, err)
+		}
+		ones, _ := ipNet.Mask.Size()
+		ip := ipNet.IP.To16()
+		if v4 := ipNet.IP.To4(); v4 != nil {
+			ip = append(make(net.IP, 12), v4...)
+			ones += 96
+		}
+
+		offset := data.Len()
+		data.Write(encodeTestValue(t, network.record))
+
+		node := root
+		for i := 0; i < ones-1; i++ {
+			bit := testBit(ip, i)
+			if node.children[bit] == nil {
+				node.children[bit] = &testNode{}
+			}
+			node = node.children[bit]
+		}
+		bit := testBit(ip, ones-1)
+		node.children[bit] = nil
+		node.records[bit] = &offset
+	}
+
+	// Number the nodes breadth first so the root is node 0
+	var nodes []*testNode
+	queue := []*testNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		node.index = len(nodes)
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			if child != nil {
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	var db bytes.Buffer
+	nodeCount := uint32(len(nodes))
+	for _, node := range nodes {
+		for side := range 2 {
+			record := nodeCount
+			switch {
+			case node.children[side] != nil:
+				record = uint32(node.children[side].index)
+			case node.records[side] != nil:
+				record = nodeCount + 16 + uint32(*node.records[side])
+			}
+			db.Write(binary.BigEndian.AppendUint32(nil, record))
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+
+	db.WriteString("\xAB\xCD\xEFMaxMind.com")
+	db.Write(encodeTestValue(t, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(built.Unix()),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{"en": "Test " + databaseType + " database"},
+		"ip_version":                  uint16(6),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(32),
+	}))
+	return db.Bytes()
+}
+
+// Opens a test database built by `buildTestDatabase`
+func openTestDatabase(t testing.TB, databaseType string, built time.Time, networks ...testNetwork) *maxminddb.Reader {
+	t.Helper()
+
+	reader, err := maxminddb.FromBytes(buildTestDatabase(t, databaseType, built, networks...))
+	if err != nil {
+		t.Fatalf("failed to open test %s database: %v", databaseType, err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+// Opens a test database built by `buildTestDatabase` as a geoip2 reader,
+// for the optional databases
+func openTestGeoIP2(t testing.TB, databaseType string, networks ...testNetwork) *geoip2.Reader {
+	t.Helper()
+
+	reader, err := geoip2.FromBytes(buildTestDatabase(t, databaseType, time.Now(), networks...))
+	if err != nil {
+		t.Fatalf("failed to open test %s database: %v", databaseType, err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader
+}
+
+// Gets the bit of the IP at the index, counting from the most significant
+func testBit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
+
+// Encodes a value in the MaxMind DB data section format. Unsigned integers
+// keep the width of their Go type, maps are encoded with sorted keys.
+func encodeTestValue(t testing.TB, value interface{}) []byte {
+	t.Helper()
+
+	switch value := value.(type) {
+	case string:
+		return append(testControl(2, len(value)), value...)
+	case float64:
+		return binary.BigEndian.AppendUint64(testControl(3, 8), math.Float64bits(value))
+	case bool:
+		size := 0
+		if value {
+			size = 1
+		}
+		return testControl(14, size)
+	case uint16:
+		return testUint(5, uint64(value))
+	case uint32:
+		return testUint(6, uint64(value))
+	case uint64:
+		return testUint(9, value)
+	case int:
+		return testUint(6, uint64(value))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		encoded := testControl(7, len(value))
+		for _, key := range keys {
+			encoded = append(encoded, encodeTestValue(t, key)...)
+			encoded = append(encoded, encodeTestValue(t, value[key])...)
+		}
+		return encoded
+	case []interface{}:
+		encoded := testControl(11, len(value))
+		for _, item := range value {
+			encoded = append(encoded, encodeTestValue(t, item)...)
+		}
+		return encoded
+	}
+
+	t.Fatalf("can't encode %T in a test database", value)
+	return nil
+}
+
+// Encodes an unsigned integer with as few bytes as it needs
+func testUint(typ int, value uint64) []byte {
+	var encoded []byte
+	for ; value > 0; value >>= 8 {
+		encoded = append([]byte{byte(value)}, encoded...)
+	}
+	return append(testControl(typ, len(encoded)), encoded...)
+}
+
+// Encodes the control byte(s) of a value of the type and size. Types past
+// 7 are extended types, which are given in a byte of their own.
+func testControl(typ int, size int) []byte {
+	var first byte
+	if typ <= 7 {
+		first = byte(typ << 5)
+	}
+
+	var extra []byte
+	switch {
+	case size < 29:
+		first |= byte(size)
+	case size < 29+256:
+		first |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 285+65536:
+		first |= 30
+		extra = binary.BigEndian.AppendUint16(nil, uint16(size-285))
+	default:
+		first |= 31
+		size -= 65821
+		extra = []byte{byte(size >> 16), byte(size >> 8), byte(size)}
+	}
+
+	control := []byte{first}
+	if typ > 7 {
+		control = append(control, byte(typ-7))
+	}
+	return append(control, extra...)
+}
+
+// Builds a test City database with 81.2.69.0/24 in the country
+func buildTestCityDatabase(t testing.TB, country string) []byte {
+	t.Helper()
+
+	return buildTestDatabase(t, "GeoIP2-City", time.Now(), testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": country},
+	}})
+}
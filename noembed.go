@@ -0,0 +1,10 @@
+//go:build !embed_db
+
+package main
+
+// No database is bundled into the binary without the embed_db tag
+var embeddedDb []byte
+
+// Whether the database is bundled into the binary instead of read from
+// GEO_FILE
+const dbEmbedded = false
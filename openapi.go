@@ -0,0 +1,292 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIRoute describes one of the geo routes in the OpenAPI document
+type openAPIRoute struct {
+	method   string
+	path     string
+	summary  string
+	params   []string
+	response gin.H
+}
+
+// Query parameters taken by every route that looks up a single IP
+var ipParams = []string{"ip", "host", "ip_version"}
+
+// Query parameters of the routes, referenced from the routes by name
+var openAPIParams = map[string]gin.H{
+	"ip":           queryParam("ip", "IP address or CIDR network to look up, or a comma-separated list of IPs. Defaults to the address of the client."),
+	"host":         queryParam("host", "Hostname to resolve and look up instead of an ip"),
+	"ip_version":   queryParam("ip_version", "Which IP version of the host to use, or to require of the ip", "4", "6"),
+	"lang":         queryParam("lang", "Locale of place names", supportedLangs...),
+	"fields":       queryParam("fields", "Comma-separated fields to return instead of all of them"),
+	"point_format": queryParam("point_format", "Whether the point is a [lat, lon] array or an object with named keys", "array", "object"),
+	"raw":          queryParam("raw", "Whether to return the complete record as the geoip2 library decodes it", "true", "false"),
+	"format":       queryParam("format", "Response format instead of JSON, geojson is only supported by /geo/point and raw by /geo/zip and /geo/country", "xml", "geojson", "raw"),
+	"pretty":       queryParam("pretty", "Whether to indent the response", "true", "false"),
+	"schema":       queryParam("schema", "Key naming of JSON responses", schemaNames()...),
+	"callback":     queryParam("callback", "JavaScript function to wrap the response in"),
+	"from":         requiredParam(queryParam("from", "IP address to measure from")),
+	"to":           requiredParam(queryParam("to", "IP address to measure to")),
+}
+
+// The geo routes, in the order they're registered. Responses are derived
+// from the response structs where the handler has one.
+var openAPIRoutes = []openAPIRoute{
+	{"GET", "/geo", "Everything known about an IP address", withParams(ipParams, "lang", "fields", "raw"), schemaOf(GeoResponse{})},
+	{"GET", "/geo/lookup", "The city record along with every other loaded database", withParams(ipParams, "lang"), schemaOf(LookupResponse{})},
+	{"GET", "/geo/point", "Lat/lon point of an IP address", withParams(ipParams, "point_format"), gin.H{
+		"oneOf": []gin.H{schemaOf(PointResponse{}), schemaOf(PointObjectResponse{})},
+	}},
+	{"GET", "/geo/zip", "Zip code of an IP address", ipParams, schemaOf(ZipResponse{})},
+	{"GET", "/geo/country", "Country of an IP address", withParams(ipParams, "lang"), objectSchema(gin.H{
+		"country_code": stringSchema,
+		"country_name": stringSchema,
+		"is_eu":        booleanSchema,
+	})},
+	{"GET", "/geo/city", "City of an IP address", withParams(ipParams, "lang"), objectSchema(gin.H{
+		"city":       stringSchema,
+		"geoname_id": integerSchema,
+	})},
+	{"GET", "/geo/asn", "Autonomous system of an IP address", ipParams, schemaOf(ASNResponse{})},
+	{"GET", "/geo/anonymous", "Whether an IP address is an anonymous network", ipParams, schemaOf(AnonymousResponse{})},
+	{"GET", "/geo/connection-type", "Connection type of an IP address", ipParams, objectSchema(gin.H{
+		"connection_type": stringSchema,
+	})},
+	{"GET", "/geo/domain", "Second-level domain of an IP address", ipParams, objectSchema(gin.H{
+		"domain": stringSchema,
+	})},
+	{"GET", "/geo/isp", "ISP and organization of an IP address", ipParams, schemaOf(ISPResponse{})},
+	{"GET", "/geo/timezone", "Time zone of an IP address", ipParams, objectSchema(gin.H{
+		"time_zone":  stringSchema,
+		"utc_offset": stringSchema,
+	})},
+	{"GET", "/geo/metro", "US metro code of an IP address", ipParams, objectSchema(gin.H{
+		"metro_code": integerSchema,
+	})},
+	{"GET", "/geo/traits", "Network traits of an IP address", ipParams, objectSchema(gin.H{
+		"is_anonymous_proxy":    booleanSchema,
+		"is_satellite_provider": booleanSchema,
+	})},
+	{"GET", "/geo/accuracy", "How precise the lookup of an IP address is", ipParams, schemaOf(AccuracyResponse{})},
+	{"GET", "/geo/subdivisions", "Subdivisions of an IP address, largest first", withParams(ipParams, "lang"), objectSchema(gin.H{
+		"subdivisions": schemaOf([]Place{}),
+	})},
+	{"GET", "/geo/continent", "Continent of an IP address", withParams(ipParams, "lang"), objectSchema(gin.H{
+		"code": stringSchema,
+		"name": stringSchema,
+	})},
+	{"GET", "/geo/registered-country", "Country an IP address is registered to", withParams(ipParams, "lang"), objectSchema(gin.H{
+		"country_code": stringSchema,
+		"country_name": stringSchema,
+		"is_eu":        booleanSchema,
+	})},
+	{"GET", "/geo/represented-country", "Country represented by the users of an IP address, e.g. a military base", withParams(ipParams, "lang"), objectSchema(gin.H{
+		"country_code": stringSchema,
+		"country_name": stringSchema,
+		"is_eu":        booleanSchema,
+		"type":         stringSchema,
+	})},
+	{"GET", "/geo/flag", "Flag emoji of the country of an IP address", ipParams, objectSchema(gin.H{
+		"flag":         stringSchema,
+		"country_code": stringSchema,
+	})},
+	{"GET", "/geo/distance", "Distance in kilometers between two IP addresses", []string{"from", "to"}, objectSchema(gin.H{
+		"km": numberSchema,
+	})},
+	{"POST", "/geo/batch", "Everything known about each of a list of IP addresses", []string{"lang"}, schemaOf([]BatchResult{})},
+	{"POST", "/geo/bulk-stats", "How many of a list of IP addresses are in each country and continent", nil, objectSchema(gin.H{
+		"total":      integerSchema,
+		"skipped":    integerSchema,
+		"unknown":    integerSchema,
+		"countries":  gin.H{"type": "object", "additionalProperties": integerSchema},
+		"continents": gin.H{"type": "object", "additionalProperties": integerSchema},
+	})},
+}
+
+var (
+	stringSchema  = gin.H{"type": "string"}
+	booleanSchema = gin.H{"type": "boolean"}
+	integerSchema = gin.H{"type": "integer"}
+	numberSchema  = gin.H{"type": "number"}
+)
+
+// The document is built on first use, once ROUTE_PREFIX has been read
+var openAPIDocument = sync.OnceValue(buildOpenAPIDocument)
+
+// Serves the OpenAPI 3 document describing the geo routes
+func openAPIHandler(c *gin.Context) {
+	c.JSON(200, openAPIDocument())
+}
+
+// Builds the OpenAPI document from `openAPIRoutes`
+func buildOpenAPIDocument() gin.H {
+	paths := gin.H{}
+	for _, route := range openAPIRoutes {
+		params := []gin.H{}
+		for _, name := range route.params {
+			params = append(params, gin.H{"$ref": "#/components/parameters/" + name})
+		}
+		if route.method == "GET" {
+			params = append(params,
+				gin.H{"$ref": "#/components/parameters/format"},
+				gin.H{"$ref": "#/components/parameters/pretty"},
+			)
+			if enableJSONP {
+				params = append(params, gin.H{"$ref": "#/components/parameters/callback"})
+			}
+		}
+		params = append(params, gin.H{"$ref": "#/components/parameters/schema"})
+
+		operation := gin.H{
+			"summary":    route.summary,
+			"parameters": params,
+			"responses": gin.H{
+				"200":     gin.H{"description": "OK", "content": jsonContent(route.response)},
+				"default": gin.H{"description": "Error", "content": jsonContent(gin.H{"$ref": "#/components/schemas/Error"})},
+			},
+		}
+		if route.method == "POST" {
+			operation["requestBody"] = gin.H{"required": true, "content": jsonContent(schemaOf(BatchRequest{}))}
+		}
+
+		path := routePrefix + route.path
+		if _, ok := paths[path]; !ok {
+			paths[path] = gin.H{}
+		}
+		paths[path].(gin.H)[strings.ToLower(route.method)] = operation
+	}
+
+	components := gin.H{
+		"parameters": openAPIParams,
+		"schemas": gin.H{
+			"Error": objectSchema(gin.H{"error": stringSchema}),
+		},
+	}
+
+	document := gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "geoip",
+			"version": version,
+		},
+		"paths":      paths,
+		"components": components,
+	}
+
+	if apiKey != "" {
+		components["securitySchemes"] = gin.H{
+			"apiKey": gin.H{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+		}
+		document["security"] = []gin.H{{"apiKey": []string{}}}
+	}
+
+	return document
+}
+
+// Builds a query parameter, limited to the values given if there are any
+func queryParam(name string, description string, values ...string) gin.H {
+	schema := gin.H{"type": "string"}
+	if len(values) > 0 {
+		schema["enum"] = values
+	}
+
+	return gin.H{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"schema":      schema,
+	}
+}
+
+// Marks a parameter as required
+func requiredParam(param gin.H) gin.H {
+	param["required"] = true
+	return param
+}
+
+// Appends parameters to a copy of the shared ones
+func withParams(params []string, more ...string) []string {
+	return append(append([]string{}, params...), more...)
+}
+
+// Wraps a schema as a JSON media type
+func jsonContent(schema gin.H) gin.H {
+	return gin.H{"application/json": gin.H{"schema": schema}}
+}
+
+// Builds an object schema with the given properties
+func objectSchema(properties gin.H) gin.H {
+	return gin.H{"type": "object", "properties": properties}
+}
+
+// Derives the schema of a value from its type, using the JSON names of
+// struct fields. The fields of embedded structs are inlined, the same as
+// encoding/json does.
+func schemaOf(value interface{}) gin.H {
+	return typeSchema(reflect.TypeOf(value))
+}
+
+// Derives the schema of a type, see `schemaOf`
+func typeSchema(t reflect.Type) gin.H {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Struct:
+		properties := gin.H{}
+		addProperties(properties, t)
+		return objectSchema(properties)
+	case reflect.Slice, reflect.Array:
+		return gin.H{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return gin.H{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.String:
+		return stringSchema
+	case reflect.Bool:
+		return booleanSchema
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return integerSchema
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return gin.H{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return numberSchema
+	default:
+		return gin.H{}
+	}
+}
+
+// Adds the JSON fields of the struct type to the properties
+func addProperties(properties gin.H, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			addProperties(properties, embedded)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
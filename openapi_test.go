@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	w := get("/openapi.json")
+	if w.Code != 200 {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Ref string `json:"$ref"`
+			} `json:"parameters"`
+			Responses map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Parameters map[string]json.RawMessage `json:"parameters"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("got OpenAPI version %q, want 3", doc.OpenAPI)
+	}
+
+	for _, path := range []string{"/geo/point", "/geo/zip"} {
+		operation, ok := doc.Paths[path]["get"]
+		if !ok {
+			t.Errorf("%s isn't listed", path)
+			continue
+		}
+		if _, ok := operation.Responses["200"]; !ok {
+			t.Errorf("%s has no 200 response", path)
+		}
+	}
+
+	// Every parameter referenced by a route is defined
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			for _, param := range operation.Parameters {
+				name := strings.TrimPrefix(param.Ref, "#/components/parameters/")
+				if _, ok := doc.Components.Parameters[name]; !ok {
+					t.Errorf("%s %s references undefined parameter %q", method, path, param.Ref)
+				}
+			}
+		}
+	}
+}
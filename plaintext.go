@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Whether the bare value should be written as plain text instead of a
+// JSON object, requested with `format=raw`
+func wantsRawValue(c *gin.Context) bool {
+	return c.Query("format") == "raw"
+}
+
+// Looks up the IP address in the request and writes the single value
+// picked from its record as text/plain, skipping JSON encoding for
+// clients that only need the one string
+func respondRawValue(c *gin.Context, value func(record *cityRecord) string) {
+	if isMultiIP(c) {
+		c.AbortWithStatusJSON(400, gin.H{"error": "raw is only supported for a single ip"})
+		return
+	}
+
+	if record, ok := getCityRecord(c); ok {
+		c.String(200, value(record))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRawValue(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/geo/zip?ip=81.2.69.142&format=raw", "NR1"},
+		{"/geo/zip?ip=216.160.83.56&format=raw", "98370"},
+		{"/geo/country?ip=81.2.69.142&format=raw", "GB"},
+	}
+
+	for _, tt := range tests {
+		w := get(tt.target)
+		if w.Code != 200 || w.Body.String() != tt.want {
+			t.Errorf("got %d %q for %s, want 200 %q", w.Code, w.Body.String(), tt.target, tt.want)
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("got Content-Type %q for %s, want text/plain", got, tt.target)
+		}
+	}
+
+	// JSON is still the default
+	if w := get("/geo/zip?ip=81.2.69.142"); w.Body.String() != `{"zip":"NR1"}` {
+		t.Errorf("got %s without format=raw, want JSON", w.Body.String())
+	}
+}
+
+func TestRawValueErrors(t *testing.T) {
+	tests := []struct {
+		target string
+		status int
+		want   string
+	}{
+		{"/geo/zip?ip=81.2.69.142,216.160.83.56&format=raw", 400, `{"error":"raw is only supported for a single ip"}`},
+		{"/geo/zip?ip=bogus&format=raw", 400, `{"error":"invalid or missing ip parameter","ip":"bogus"}`},
+		{"/geo/zip?ip=10.0.0.1&format=raw", 422, `{"error":"private or reserved ip","ip":"10.0.0.1"}`},
+	}
+
+	// Errors are JSON like everywhere else
+	for _, tt := range tests {
+		if w := get(tt.target); w.Code != tt.status || w.Body.String() != tt.want {
+			t.Errorf("got %d %s for %s, want %d %s", w.Code, w.Body.String(), tt.target, tt.status, tt.want)
+		}
+	}
+}
+
+func BenchmarkZipHandler(b *testing.B) {
+	// Only the handler, so the middleware doesn't drown out the encoding
+	router := gin.New()
+	router.GET("/geo/zip", zipHandler)
+
+	for _, bm := range []struct {
+		name   string
+		target string
+	}{
+		{"json", "/geo/zip?ip=81.2.69.142"},
+		{"raw", "/geo/zip?ip=81.2.69.142&format=raw"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			req := httptest.NewRequest("GET", bm.target, nil)
+			b.ReportAllocs()
+			for b.Loop() {
+				router.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether to serve runtime profiles under /debug/pprof. They expose
+// internals of the process, so it's off by default.
+var enablePprof = envBool("ENABLE_PPROF", false)
+
+// Cancelled when the server starts shutting down so long-running profiles
+// and traces end instead of holding up the graceful shutdown
+var pprofShutdown, stopPprof = context.WithCancel(context.Background())
+
+// Mounts the net/http/pprof handlers on the router when ENABLE_PPROF is set
+func registerPprof(router *gin.Engine) {
+	if !enablePprof {
+		return
+	}
+
+	router.GET("/debug/pprof/*name", noStore, pprofHandler)
+	router.POST("/debug/pprof/*name", noStore, pprofHandler)
+}
+
+// Serves the named profile. The index also serves the named runtime
+// profiles such as heap, goroutine and block.
+func pprofHandler(c *gin.Context) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	stop := context.AfterFunc(pprofShutdown, cancel)
+	defer stop()
+
+	r := c.Request.WithContext(ctx)
+	switch strings.TrimPrefix(c.Param("name"), "/") {
+	case "cmdline":
+		pprof.Cmdline(c.Writer, r)
+	case "profile":
+		pprof.Profile(c.Writer, r)
+	case "symbol":
+		pprof.Symbol(c.Writer, r)
+	case "trace":
+		pprof.Trace(c.Writer, r)
+	default:
+		pprof.Index(c.Writer, r)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPprofEnabled(t *testing.T) {
+	setConfig(t, &enablePprof, true)
+
+	for _, target := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+		if w := get(target); w.Code != 200 {
+			t.Errorf("got status %d for %s, want 200", w.Code, target)
+		}
+	}
+}
+
+func TestPprofDisabled(t *testing.T) {
+	setConfig(t, &enablePprof, false)
+
+	if w := get("/debug/pprof/"); w.Code != 404 {
+		t.Errorf("got status %d without ENABLE_PPROF, want 404", w.Code)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// Requests per second allowed for each client IP, 0 disables rate limiting
+var rateLimit = envFloat("RATE_LIMIT", 0)
+
+// Number of requests a client may burst above the rate, defaults to the
+// rate rounded up
+var rateBurst = envInt("RATE_BURST", int(math.Ceil(rateLimit)))
+
+// Length of the prefix IPv6 clients are limited by, since a single client
+// usually holds a whole /64
+var rateLimitIPv6Prefix = envInt("RATE_LIMIT_IPV6_PREFIX", 64)
+
+// Clients that haven't made a request for this long are forgotten
+const rateLimiterIdle = 3 * time.Minute
+
+// clientLimiter is the token bucket of a single client
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter tracks a token bucket per client IP
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+// Gets the token bucket for the client, creating it on its first request
+func (l *rateLimiter) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	client, ok := l.clients[key]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(rate.Limit(rateLimit), rateBurst)}
+		l.clients[key] = client
+	}
+	client.lastSeen = time.Now()
+	return client.limiter
+}
+
+// Forgets clients that have been idle so the map can't grow unbounded
+func (l *rateLimiter) evict() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, client := range l.clients {
+		if time.Since(client.lastSeen) > rateLimiterIdle {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// Gets the key a client is limited by: IPv4-mapped addresses share the
+// bucket of their IPv4 form, and IPv6 addresses that of their prefix
+func rateLimitKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+	prefix := net.CIDRMask(rateLimitIPv6Prefix, 8*net.IPv6len)
+	return (&net.IPNet{IP: ip.Mask(prefix), Mask: prefix}).String()
+}
+
+// Evicts idle clients every minute until the context is done
+func (l *rateLimiter) evictLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.evict()
+		}
+	}
+}
+
+// Creates the rate limiting middleware, or nil when it's disabled.
+// Clients over their limit get a 429 with a Retry-After header.
+func rateLimitMiddleware() gin.HandlerFunc {
+	if rateLimit <= 0 {
+		return nil
+	}
+	if rateBurst < 1 {
+		fatal("RATE_BURST must be at least 1", "value", rateBurst)
+	}
+	if rateLimitIPv6Prefix < 0 || rateLimitIPv6Prefix > 8*net.IPv6len {
+		fatal("RATE_LIMIT_IPV6_PREFIX must be between 0 and 128", "value", rateLimitIPv6Prefix)
+	}
+
+	limiter := &rateLimiter{clients: map[string]*clientLimiter{}}
+	go limiter.evictLoop(background)
+
+	return func(c *gin.Context) {
+		// Without an address, e.g. from a Unix socket peer that didn't
+		// forward one, every such client would share a single limit
+		ip := clientIP(c)
+		if ip == nil {
+			c.Next()
+			return
+		}
+
+		reservation := limiter.get(rateLimitKey(ip)).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			c.AbortWithStatusJSON(429, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Runs the background goroutines started during the test, such as the
+// eviction loops of rate limiters, until the test is done
+func useBackground(t testing.TB) {
+	t.Helper()
+
+	ctx, stop := context.WithCancel(context.Background())
+	setConfig(t, &background, ctx)
+	t.Cleanup(stop)
+}
+
+func TestRateLimit(t *testing.T) {
+	useBackground(t)
+	setConfig(t, &rateLimit, 1)
+	setConfig(t, &rateBurst, 3)
+	router := newRouter()
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := range 3 {
+		if w := request("81.2.69.142:1234"); w.Code != 200 {
+			t.Fatalf("got status %d for request %d within the burst, want 200", w.Code, i+1)
+		}
+	}
+
+	w := request("81.2.69.142:1234")
+	if w.Code != 429 {
+		t.Fatalf("got status %d past the burst, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Errorf("got Retry-After %q, want 1", w.Header().Get("Retry-After"))
+	}
+
+	// Each client has its own limit
+	if w := request("216.160.83.56:1234"); w.Code != 200 {
+		t.Errorf("got status %d for another client, want 200", w.Code)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		ip     string
+		prefix int
+		want   string
+	}{
+		{"81.2.69.142", 64, "81.2.69.142"},
+		{"::ffff:81.2.69.142", 64, "81.2.69.142"},
+		{"2001:db8:1:2:3:4:5:6", 64, "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff::1", 64, "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:3:4:5:6", 48, "2001:db8:1::/48"},
+		{"2001:db8:1:2:3:4:5:6", 128, "2001:db8:1:2:3:4:5:6/128"},
+	}
+	for _, test := range tests {
+		setConfig(t, &rateLimitIPv6Prefix, test.prefix)
+		if got := rateLimitKey(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("got key %q for %s with a /%d prefix, want %q", got, test.ip, test.prefix, test.want)
+		}
+	}
+}
+
+func TestRateLimitSharedPrefix(t *testing.T) {
+	useBackground(t)
+	setConfig(t, &rateLimit, 1)
+	setConfig(t, &rateBurst, 1)
+	router := newRouter()
+
+	request := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Another address in the same /64, or the mapped form of the same IPv4
+	// address, doesn't get a new limit
+	if code := request("[2001:db8:1:2::1]:1234"); code != 200 {
+		t.Fatalf("got status %d for the first request, want 200", code)
+	}
+	if code := request("[2001:db8:1:2::2]:1234"); code != 429 {
+		t.Errorf("got status %d from another address in the /64, want 429", code)
+	}
+	if code := request("[2001:db8:1:3::1]:1234"); code != 200 {
+		t.Errorf("got status %d from another /64, want 200", code)
+	}
+	if code := request("81.2.69.142:1234"); code != 200 {
+		t.Fatalf("got status %d for the first IPv4 request, want 200", code)
+	}
+	if code := request("[::ffff:81.2.69.142]:1234"); code != 429 {
+		t.Errorf("got status %d from the IPv4-mapped address, want 429", code)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	setConfig(t, &rateLimit, 0)
+
+	if rateLimitMiddleware() != nil {
+		t.Error("got a rate limiter with RATE_LIMIT=0")
+	}
+}
+
+func TestRateLimitWithoutClientIP(t *testing.T) {
+	useBackground(t)
+	setConfig(t, &rateLimit, 1)
+	setConfig(t, &rateBurst, 1)
+	setConfig(t, &unixSocket, "/run/geoip.sock")
+	router := newRouter()
+
+	// Socket peers that don't forward an address aren't limited together
+	for i := range 3 {
+		req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+		req.RemoteAddr = "@"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("got status %d for request %d without a client address, want 200", w.Code, i+1)
+		}
+	}
+}
+
+func TestRateLimitEvictLoop(t *testing.T) {
+	limiter := &rateLimiter{clients: map[string]*clientLimiter{}}
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		limiter.evictLoop(ctx)
+		close(done)
+	}()
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the eviction loop didn't stop with its context")
+	}
+}
+
+func TestRateLimitEvict(t *testing.T) {
+	limiter := &rateLimiter{clients: map[string]*clientLimiter{}}
+	limiter.get("81.2.69.142")
+	limiter.get("216.160.83.56")
+	limiter.clients["81.2.69.142"].lastSeen = time.Now().Add(-rateLimiterIdle - time.Second)
+
+	limiter.evict()
+	if _, ok := limiter.clients["81.2.69.142"]; ok {
+		t.Error("an idle client wasn't evicted")
+	}
+	if _, ok := limiter.clients["216.160.83.56"]; !ok {
+		t.Error("an active client was evicted")
+	}
+}
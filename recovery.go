@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovers from panics in handlers, logging the panic along with its stack
+// and responding with a JSON 500 like the API's other errors. The request
+// ID is included so the response can be matched to the log.
+func recoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		id := c.GetString(requestIDKey)
+		slog.Error("panic while handling request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"request_id", id,
+			"error", fmt.Sprint(err),
+			"stack", string(debug.Stack()),
+		)
+
+		body := gin.H{"error": "internal server error"}
+		if id != "" {
+			body["request_id"] = id
+		}
+		c.AbortWithStatusJSON(500, body)
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecovery(t *testing.T) {
+	logs := captureLogs(t, nil)
+
+	router := gin.New()
+	router.Use(recoveryMiddleware(), requestIDMiddleware)
+	router.GET("/panic", func(c *gin.Context) { panic("handler failed") })
+
+	req := httptest.NewRequest("GET", "/panic", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != 500 || w.Body.String() != `{"error":"internal server error","request_id":"abc-123"}` {
+		t.Errorf("got %d %s for a panic, want a JSON 500 with the request ID", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("got Content-Type %q, want JSON", got)
+	}
+
+	lines := logLines(t, logs, "panic while handling request")
+	if len(lines) != 1 {
+		t.Fatalf("got %d panic logs, want 1", len(lines))
+	}
+	if lines[0]["error"] != "handler failed" || lines[0]["request_id"] != "abc-123" {
+		t.Errorf("got panic log %v, want the error and request ID", lines[0])
+	}
+	if stack, _ := lines[0]["stack"].(string); !strings.Contains(stack, "TestRecovery") {
+		t.Errorf("got stack %q, want the panicking handler's", stack)
+	}
+}
+
+func TestRecoveryWithoutRequestID(t *testing.T) {
+	captureLogs(t, nil)
+
+	router := gin.New()
+	router.Use(recoveryMiddleware())
+	router.GET("/panic", func(c *gin.Context) { panic("handler failed") })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != 500 || w.Body.String() != `{"error":"internal server error"}` {
+		t.Errorf("got %d %s for a panic, want a JSON 500", w.Code, w.Body.String())
+	}
+}
+
+func TestRecoveryCountedAndLogged(t *testing.T) {
+	logs := captureLogs(t, nil)
+	setConfig(t, &lookupTimeout, 0)
+	setConfig(t, &cityCache, nil)
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) { panic("lookup failed") })
+
+	requests := testutil.ToFloat64(requestsTotal.WithLabelValues("/geo/zip", "500"))
+	outcomes := testutil.ToFloat64(lookupOutcomes.WithLabelValues("error"))
+
+	req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	if w := serveRequest(req); w.Code != 500 || w.Body.String() != `{"error":"internal server error","request_id":"abc-123"}` {
+		t.Fatalf("got %d %s for a panic, want a JSON 500 with the request ID", w.Code, w.Body.String())
+	}
+
+	if got := testutil.ToFloat64(requestsTotal.WithLabelValues("/geo/zip", "500")) - requests; got != 1 {
+		t.Errorf("counted %v 500s in the requests metric, want 1", got)
+	}
+	if got := testutil.ToFloat64(lookupOutcomes.WithLabelValues("error")) - outcomes; got != 1 {
+		t.Errorf("counted %v error outcomes, want 1", got)
+	}
+	lines := logLines(t, logs, "request")
+	if len(lines) != 1 || lines[0]["status"] != float64(500) || lines[0]["request_id"] != "abc-123" {
+		t.Errorf("got request logs %v, want the 500 logged", lines)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header the request ID is read from and echoed back in
+var requestIDHeader = envString("REQUEST_ID_HEADER", "X-Request-ID")
+
+// Context key the request ID is stored under
+const requestIDKey = "request_id"
+
+// Longest incoming request ID that's accepted, longer ones are replaced
+const maxRequestIDLength = 128
+
+// Tags every request with an ID to correlate it across systems. The ID
+// from the incoming header is kept when there is one, otherwise a UUID is
+// generated, and it's echoed back in the response and logged.
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID(id) {
+		id = newUUID()
+	}
+
+	c.Set(requestIDKey, id)
+	c.Header(requestIDHeader, id)
+	c.Next()
+}
+
+// Whether an incoming request ID can be used as is. It's logged and
+// echoed in a header, so only short printable ASCII IDs are accepted.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Generates a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
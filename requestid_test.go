@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// Serves a zip code lookup with the request ID header
+func getWithRequestID(id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+	if id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	return serveRequest(req)
+}
+
+func TestRequestIDEchoed(t *testing.T) {
+	logs := captureLogs(t, nil)
+
+	if got := getWithRequestID("abc-123").Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("got X-Request-ID %q, want the incoming abc-123", got)
+	}
+
+	lines := logLines(t, logs, "request")
+	if len(lines) != 1 || lines[0]["request_id"] != "abc-123" {
+		t.Errorf("got request logs %v, want one with the request ID", lines)
+	}
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"missing", ""},
+		{"too long", strings.Repeat("a", 129)},
+		{"unprintable", "abc\x01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getWithRequestID(tt.id).Header().Get("X-Request-ID"); !uuidPattern.MatchString(got) {
+				t.Errorf("got X-Request-ID %q, want a generated UUID", got)
+			}
+		})
+	}
+
+	if getWithRequestID("").Header().Get("X-Request-ID") == getWithRequestID("").Header().Get("X-Request-ID") {
+		t.Error("got the same generated ID for two requests")
+	}
+}
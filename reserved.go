@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+)
+
+// Whether lookups of private and reserved IPs are answered with a 422
+// rather than an empty or not found result
+var rejectPrivateIPs = envBool("REJECT_PRIVATE_IPS", true)
+
+// Reserved ranges not covered by the net.IP checks, which are never in
+// the database: shared address space (CGNAT), the documentation ranges,
+// and the reserved 240.0.0.0/4 block
+var reservedNets = mustParseCIDRs(
+	"100.64.0.0/10",
+	"192.0.2.0/24",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"2001:db8::/32",
+)
+
+// Parses hardcoded CIDRs, panicking on a bad one
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, network)
+	}
+	return nets
+}
+
+// Whether the IP is private (RFC 1918 or a unique local IPv6 address),
+// loopback, link-local, multicast, unspecified, or otherwise reserved
+func isReservedIP(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range reservedNets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the IP should be rejected rather than looked up
+func rejectIP(ip net.IP) bool {
+	return rejectPrivateIPs && isReservedIP(ip)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsReservedIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		reserved bool
+	}{
+		{"10.1.2.3", true},
+		{"127.0.0.1", true},
+		{"192.168.0.1", true},
+		{"169.254.1.1", true},
+		{"100.64.0.1", true},
+		{"203.0.113.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"2001:db8::1", true},
+		{"81.2.69.142", false},
+		{"2001:218::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isReservedIP(net.ParseIP(tt.ip)); got != tt.reserved {
+			t.Errorf("got reserved=%t for %s, want %t", got, tt.ip, tt.reserved)
+		}
+	}
+}
+
+func TestRejectPrivateIPs(t *testing.T) {
+	tests := []struct {
+		ip     string
+		status int
+	}{
+		{"10.1.2.3", 422},
+		{"127.0.0.1", 422},
+		{"81.2.69.142", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			var body map[string]string
+			decodeResponse(t, get("/geo/zip?ip="+tt.ip), tt.status, &body)
+			if tt.status == 422 && (body["error"] != "private or reserved ip" || body["ip"] != tt.ip) {
+				t.Errorf("got %v", body)
+			}
+		})
+	}
+}
+
+func TestRejectPrivateIPsDisabled(t *testing.T) {
+	setConfig(t, &rejectPrivateIPs, false)
+
+	// Looked up like any other IP, which the database doesn't have
+	if w := get("/geo/zip?ip=10.1.2.3"); w.Code != 404 {
+		t.Errorf("got status %d with REJECT_PRIVATE_IPS=false, want 404", w.Code)
+	}
+}
+
+func TestRejectPrivateIPsDistance(t *testing.T) {
+	var body struct {
+		Params map[string]string `json:"params"`
+	}
+	decodeResponse(t, get("/geo/distance?from=81.2.69.142&to=10.1.2.3"), 422, &body)
+
+	if body.Params["to"] == "" || body.Params["from"] != "" {
+		t.Errorf("got params %v, want only to rejected", body.Params)
+	}
+}
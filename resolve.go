@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long resolving the `host` parameter may take
+var resolveTimeout = envDuration("RESOLVE_TIMEOUT", 2*time.Second)
+
+// Resolver used for the `host` parameter, replaceable with a stub
+var hostResolver interface {
+	LookupIP(ctx context.Context, network string, host string) ([]net.IP, error)
+} = net.DefaultResolver
+
+// Gets the IP version requested with the `ip_version` parameter, "4" or
+// "6", or an empty string when it isn't given. Other values, including an
+// empty `ip_version=`, end the request with a 400 and the second parameter
+// returned is false.
+func getIPVersion(c *gin.Context) (string, bool) {
+	version, given := c.GetQuery("ip_version")
+	if given && version != "4" && version != "6" {
+		c.AbortWithStatusJSON(400, gin.H{
+			"error":      "ip_version must be 4 or 6",
+			"ip_version": version,
+		})
+		return "", false
+	}
+	return version, true
+}
+
+// Checks an IP given in the `ip` parameter is of the version requested
+// with `ip_version`, if any. If it isn't, the request is ended with a 400
+// and false is returned.
+func checkIPVersion(c *gin.Context, ip net.IP) bool {
+	version, ok := getIPVersion(c)
+	if !ok {
+		return false
+	}
+	if matchesIPVersion(ip, version) {
+		return true
+	}
+
+	c.AbortWithStatusJSON(400, gin.H{
+		"error": ipVersionError(version),
+		"ip":    ip.String(),
+	})
+	return false
+}
+
+// Whether the IP is of the version, which is any when it's empty
+func matchesIPVersion(ip net.IP, version string) bool {
+	return version == "" || (ip.To4() != nil) == (version == "4")
+}
+
+// The error for an IP that isn't of the requested version
+func ipVersionError(version string) string {
+	return "ip is not an IPv" + version + " address"
+}
+
+// Resolves the `host` parameter to the IP address to look up. With an
+// `ip_version` parameter only addresses of that version are used,
+// otherwise IPv4 addresses are preferred. If the host can't be resolved,
+// the request is ended with a 400 and the second parameter returned is
+// false.
+func resolveHost(c *gin.Context, host string) (net.IP, bool) {
+	version, ok := getIPVersion(c)
+	if !ok {
+		return nil, false
+	}
+
+	ctx := c.Request.Context()
+	if resolveTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, resolveTimeout)
+		defer cancel()
+	}
+
+	ips, err := hostResolver.LookupIP(ctx, "ip"+version, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{
+			"error": "failed to resolve host: " + err.Error(),
+			"host":  host,
+		})
+		return nil, false
+	}
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, true
+		}
+	}
+	return ips[0], true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHostParameter(t *testing.T) {
+	// IPv4 addresses are preferred
+	stubHosts(t, "2001:218::1", "81.2.69.142")
+
+	var body struct {
+		CountryCode string `json:"country_code"`
+	}
+	decodeResponse(t, get("/geo/country?host=example.com"), 200, &body)
+	if body.CountryCode != "GB" {
+		t.Errorf("got country %q, want GB for the IPv4 address", body.CountryCode)
+	}
+}
+
+func TestHostParameterUnresolved(t *testing.T) {
+	useResolver(t, stubResolver{err: errors.New("no such host")})
+
+	var body map[string]string
+	decodeResponse(t, get("/geo/country?host=missing.example"), 400, &body)
+	if body["error"] != "failed to resolve host: no such host" || body["host"] != "missing.example" {
+		t.Errorf("got %v for a host that doesn't resolve", body)
+	}
+}
+
+func TestHostParameterNoAddresses(t *testing.T) {
+	stubHosts(t)
+
+	if w := get("/geo/country?host=example.com"); w.Code != 400 {
+		t.Errorf("got status %d for a host without addresses, want 400", w.Code)
+	}
+}
+
+func TestHostParameterRejected(t *testing.T) {
+	stubHosts(t, "127.0.0.1")
+
+	if w := get("/geo/country?host=localhost"); w.Code != 422 {
+		t.Errorf("got status %d for a host resolving to loopback, want 422", w.Code)
+	}
+}
+
+func TestIPVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		status int
+		want   string
+	}{
+		{"IPv4 only", "/geo/country?ip=81.2.69.142&ip_version=4", 200, `{"country_code":"GB","country_name":"United Kingdom","is_eu":false}`},
+		{"IPv6 only", "/geo/country?ip=2001:218::1&ip_version=6", 200, `{"country_code":"JP","country_name":"Japan","is_eu":false}`},
+		{"IPv4 as IPv6", "/geo/country?ip=81.2.69.142&ip_version=6", 400, `{"error":"ip is not an IPv6 address","ip":"81.2.69.142"}`},
+		{"IPv6 as IPv4", "/geo/country?ip=2001:218::1&ip_version=4", 400, `{"error":"ip is not an IPv4 address","ip":"2001:218::1"}`},
+		{"unknown version", "/geo/country?ip=81.2.69.142&ip_version=5", 400, `{"error":"ip_version must be 4 or 6","ip_version":"5"}`},
+		{"empty version", "/geo/country?ip=81.2.69.142&ip_version=", 400, `{"error":"ip_version must be 4 or 6","ip_version":""}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := get(tt.target); w.Code != tt.status || w.Body.String() != tt.want {
+				t.Errorf("got %d %s, want %d %s", w.Code, w.Body.String(), tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostParameterIPVersion(t *testing.T) {
+	stubHosts(t, "2001:218::1", "81.2.69.142")
+
+	var body struct {
+		CountryCode string `json:"country_code"`
+	}
+	decodeResponse(t, get("/geo/country?host=example.com&ip_version=6"), 200, &body)
+	if body.CountryCode != "JP" {
+		t.Errorf("got country %q with ip_version=6, want JP for the IPv6 address", body.CountryCode)
+	}
+
+	stubHosts(t, "81.2.69.142")
+	if w := get("/geo/country?host=example.com&ip_version=6"); w.Code != 400 {
+		t.Errorf("got status %d for a host without IPv6 addresses, want 400", w.Code)
+	}
+}
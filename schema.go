@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Key naming schemes of JSON responses that can be picked with the
+// `schema` query parameter, each mapping a field's standard key to the one
+// used instead. Keys that aren't listed are left as they are.
+var responseSchemas = map[string]map[string]string{
+	"standard": {},
+	"legacy": {
+		"latitude":  "lat",
+		"longitude": "lng",
+		"zip":       "zipcode",
+		"postal":    "zipcode",
+		"time_zone": "timezone",
+	},
+}
+
+// Schema used when the request doesn't pick one
+var defaultSchema = envString("RESPONSE_SCHEMA", "standard")
+
+// Names of the response schemas, sorted for error messages
+func schemaNames() []string {
+	names := make([]string, 0, len(responseSchemas))
+	for name := range responseSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Gets the key mapping of the schema requested with the `schema` query
+// parameter. Unknown schemas end the request with a 400 and the second
+// parameter returned is false.
+func getSchema(c *gin.Context) (map[string]string, bool) {
+	name := c.DefaultQuery("schema", defaultSchema)
+	if keys, ok := responseSchemas[name]; ok {
+		return keys, true
+	}
+
+	c.AbortWithStatusJSON(400, gin.H{
+		"error":             fmt.Sprintf("unsupported schema %q", name),
+		"supported_schemas": schemaNames(),
+	})
+	return nil, false
+}
+
+// Renames the keys of the response to those of the schema. The response
+// is round-tripped through JSON so struct fields are renamed too.
+func applySchema(obj interface{}, keys map[string]string) (interface{}, error) {
+	if len(keys) == 0 {
+		return obj, nil
+	}
+
+	encoded, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(encoded, &value); err != nil {
+		return nil, err
+	}
+	return renameKeys(value, keys), nil
+}
+
+// Renames the keys of every object in a decoded JSON value
+func renameKeys(value interface{}, keys map[string]string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if to, ok := keys[key]; ok {
+				key = to
+			}
+			renamed[key] = renameKeys(item, keys)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range value {
+			value[i] = renameKeys(item, keys)
+		}
+		return value
+	default:
+		return value
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResponseSchemas(t *testing.T) {
+	tests := []struct {
+		schema   string
+		keys     []string
+		location []string
+	}{
+		{"standard", []string{"postal", "time_zone", "location"}, []string{"latitude", "longitude"}},
+		{"legacy", []string{"zipcode", "timezone", "location"}, []string{"lat", "lng"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			var body map[string]json.RawMessage
+			decodeResponse(t, get("/geo?ip=81.2.69.142&schema="+tt.schema), 200, &body)
+			for _, key := range tt.keys {
+				if _, ok := body[key]; !ok {
+					t.Errorf("got no %s key in %v", key, body)
+				}
+			}
+
+			var location map[string]float64
+			if err := json.Unmarshal(body["location"], &location); err != nil {
+				t.Fatal(err)
+			}
+			if location[tt.location[0]] != 52.6259 || location[tt.location[1]] != 1.3032 {
+				t.Errorf("got location %v, want %s and %s keys", location, tt.location[0], tt.location[1])
+			}
+		})
+	}
+}
+
+func TestResponseSchemaZip(t *testing.T) {
+	for schema, want := range map[string]string{
+		"standard": `{"zip":"NR1"}`,
+		"legacy":   `{"zipcode":"NR1"}`,
+	} {
+		if w := get("/geo/zip?ip=81.2.69.142&schema=" + schema); w.Body.String() != want {
+			t.Errorf("got %s with the %s schema, want %s", w.Body.String(), schema, want)
+		}
+	}
+}
+
+// Posts a batch of the IPs in the legacy schema, accepting the content type
+func postLegacyBatch(ips string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/geo/batch?schema=legacy", strings.NewReader(`{"ips": [`+ips+`]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	return serveRequest(req)
+}
+
+func TestResponseSchemaStreamed(t *testing.T) {
+	line := strings.TrimSpace(postLegacyBatch(`"81.2.69.142"`, ndjsonContentType).Body.String())
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &result); err != nil {
+		t.Fatalf("invalid line %q: %v", line, err)
+	}
+	if result["zipcode"] != "NR1" || result["timezone"] != "Europe/London" {
+		t.Errorf("got line %s, want legacy keys", line)
+	}
+
+	rows, err := csv.NewReader(postLegacyBatch(`"81.2.69.142"`, csvContentType).Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if want := "ip,country_code,city,lat,lon,zipcode,error"; len(rows) != 2 || strings.Join(rows[0], ",") != want {
+		t.Errorf("got rows %q, want the header %q", rows, want)
+	}
+}
+
+func TestResponseSchemaGeoJSON(t *testing.T) {
+	// GeoJSON keys are fixed by the format, whatever the schema
+	standard := get("/geo/point?ip=81.2.69.142&format=geojson").Body.String()
+	legacy := get("/geo/point?ip=81.2.69.142&format=geojson&schema=legacy").Body.String()
+
+	var want, got map[string]interface{}
+	if err := json.Unmarshal([]byte(standard), &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(legacy), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s with the legacy schema, want %s", legacy, standard)
+	}
+}
+
+func TestResponseSchemaInvalid(t *testing.T) {
+	w := get("/geo/zip?ip=81.2.69.142&schema=bogus")
+	if w.Code != 400 || w.Body.String() != `{"error":"unsupported schema \"bogus\"","supported_schemas":["legacy","standard"]}` {
+		t.Errorf("got %d %s for an unknown schema, want a 400", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// How long in-flight requests, including streamed batches, have to finish
+// once the server starts shutting down
+var shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 5*time.Second)
+
+// Cancelled well before the shutdown timeout runs out, so streamed
+// batches still in progress end with a final error line rather than being
+// cut off mid-record when the server exits
+var streamShutdown, stopStreams = context.WithCancel(context.Background())
+
+// Error reported on the last line of a streamed batch ended by shutdown
+const streamShutdownError = "server shutting down"
+
+// Subscribes to the signals that shut the server down. The channel is
+// buffered so a signal that arrives before it's waited on isn't dropped.
+func notifyShutdown() chan os.Signal {
+	quit := make(chan os.Signal, 1)
+	// kill (no param) default send syscall.SIGTERM
+	// kill -2 is syscall.SIGINT
+	// kill -9 is syscall.SIGKILL but can't be caught, so don't need to add it
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	return quit
+}
+
+// Shuts the server down gracefully, giving the requests it's handling
+// SHUTDOWN_TIMEOUT to finish
+func shutdownServer(srv *http.Server) error {
+	// The context is used to inform the server how long it has to finish
+	// the requests it is currently handling
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return srv.Shutdown(ctx)
+}
+
+// Gives streamed batches half of the shutdown timeout to finish before
+// they're stopped, leaving the rest for what's already written to reach
+// the client. Set as an OnShutdown hook of the server.
+func drainStreams() {
+	time.AfterFunc(shutdownTimeout/2, stopStreams)
+}
+
+// Number of connections the server currently has open
+var openConns atomic.Int64
+
+// Keeps count of the open connections, set as the ConnState hook of the
+// server so the connections still open can be reported on shutdown
+func trackConn(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		openConns.Add(1)
+	case http.StateHijacked, http.StateClosed:
+		openConns.Add(-1)
+	}
+}
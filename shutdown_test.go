@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownOnSIGTERM(t *testing.T) {
+	setConfig(t, &unixSocket, "")
+
+	quit := notifyShutdown()
+	defer signal.Stop(quit)
+
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	shutdown := make(chan struct{})
+	srv.RegisterOnShutdown(func() { close(shutdown) })
+	listener, err := listen(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(srv, listener)
+
+	// Sent before anything is waiting on the channel, which must keep it
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case <-quit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM wasn't delivered")
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server wasn't shut down")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	setConfig(t, &unixSocket, "")
+	setConfig(t, &shutdownTimeout, 50*time.Millisecond)
+
+	// A request that outlasts the timeout
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	listener, err := listen(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(srv, listener)
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	start := time.Now()
+	err = shutdownServer(srv)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v with a request still in flight, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Errorf("shutdown took %s, want about the 50ms SHUTDOWN_TIMEOUT", elapsed)
+	}
+}
+
+func TestShutdownDuringStream(t *testing.T) {
+	setConfig(t, &unixSocket, "")
+	setConfig(t, &shutdownTimeout, 200*time.Millisecond)
+	streams, stop := context.WithCancel(context.Background())
+	setConfig(t, &streamShutdown, streams)
+	setConfig(t, &stopStreams, stop)
+
+	// Each IP is looked up slowly enough for the stream to outlast the
+	// shutdown, and the cache is off so every one reaches the database
+	setConfig(t, &cityCache, nil)
+	streaming := make(chan struct{})
+	var lookups atomic.Int64
+	setConfig(t, &cityLookup, func(ip net.IP) (*cityRecord, error) {
+		if lookups.Add(1) == 10 {
+			close(streaming)
+		}
+		time.Sleep(time.Millisecond)
+		return geoDb.City(ip)
+	})
+
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: newRouter()}
+	srv.RegisterOnShutdown(drainStreams)
+	listener, err := listen(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(srv, listener)
+
+	ips := strings.Repeat(`"81.2.69.142", `, 999) + `"81.2.69.142"`
+	req, err := http.NewRequest("POST", "http://"+listener.Addr().String()+"/geo/batch", strings.NewReader(`{"ips": [`+ips+`]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", ndjsonContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	<-streaming
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- shutdownServer(srv) }()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("got a truncated line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) < 10 || len(lines) > 1000 {
+		t.Fatalf("got %d lines, want the stream stopped partway through", len(lines))
+	}
+	if last := lines[len(lines)-1]; last["error"] != streamShutdownError {
+		t.Errorf("got %v last, want a %q error line", last, streamShutdownError)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("got %v shutting down, want the stream to finish in time", err)
+	}
+}
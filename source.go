@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether geo responses carry an X-Geo-Source header naming where their
+// data came from, for debugging
+var debugSource = envBool("DEBUG_SOURCE", false)
+
+// The sources of a request's data, in the order they were first used
+type lookupSources struct {
+	mu    sync.Mutex
+	names []string
+}
+
+type lookupSourcesKey struct{}
+
+// Records that a source, such as "city", "cache" or "country-fallback",
+// produced part of the response to the request of the context. It's a
+// no-op when DEBUG_SOURCE isn't set.
+func addSource(ctx context.Context, name string) {
+	sources, ok := ctx.Value(lookupSourcesKey{}).(*lookupSources)
+	if !ok {
+		return
+	}
+
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	if !slices.Contains(sources.names, name) {
+		sources.names = append(sources.names, name)
+	}
+}
+
+// Sends the sources of a geo response in the X-Geo-Source header. Sources
+// used after the response has started, e.g. by a streamed batch, are left
+// out. Returns nil when DEBUG_SOURCE isn't set.
+func sourceMiddleware() gin.HandlerFunc {
+	if !debugSource {
+		return nil
+	}
+
+	return func(c *gin.Context) {
+		sources := &lookupSources{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), lookupSourcesKey{}, sources))
+		c.Writer = &sourceWriter{ResponseWriter: c.Writer, sources: sources}
+		c.Next()
+	}
+}
+
+// Adds the X-Geo-Source header as the response's status is written
+type sourceWriter struct {
+	gin.ResponseWriter
+	sources *lookupSources
+}
+
+func (w *sourceWriter) WriteHeader(code int) {
+	w.sources.mu.Lock()
+	if len(w.sources.names) > 0 {
+		w.Header().Set("X-Geo-Source", strings.Join(w.sources.names, ", "))
+	}
+	w.sources.mu.Unlock()
+	w.ResponseWriter.WriteHeader(code)
+}
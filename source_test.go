@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestSourceHeader(t *testing.T) {
+	setConfig(t, &debugSource, true)
+	purgeCache()
+
+	if got := get("/geo/zip?ip=81.2.69.142").Header().Get("X-Geo-Source"); got != "city" {
+		t.Errorf("got X-Geo-Source %q for the first lookup, want city", got)
+	}
+	if got := get("/geo/zip?ip=81.2.69.142").Header().Get("X-Geo-Source"); got != "cache" {
+		t.Errorf("got X-Geo-Source %q for a repeated lookup, want cache", got)
+	}
+
+	setConfig(t, &asnDb, openTestGeoIP2(t, "GeoLite2-ASN", testNetwork{"81.2.69.0/24", map[string]interface{}{
+		"autonomous_system_number": uint32(20712),
+	}}))
+	if got := get("/geo/asn?ip=81.2.69.142").Header().Get("X-Geo-Source"); got != "asn" {
+		t.Errorf("got X-Geo-Source %q for an ASN lookup, want asn", got)
+	}
+}
+
+func TestSourceHeaderDisabled(t *testing.T) {
+	setConfig(t, &debugSource, false)
+
+	if got := get("/geo/zip?ip=81.2.69.142").Header().Values("X-Geo-Source"); len(got) != 0 {
+		t.Errorf("got X-Geo-Source %q without DEBUG_SOURCE", got)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Whether the IP recorded on lookup spans is hashed rather than kept as is
+var traceHashIP = envBool("TRACE_HASH_IP", false)
+
+// Service name spans are reported under unless OTEL_SERVICE_NAME is set
+const traceServiceName = "geoip"
+
+// Traces are no-ops until `initTracing` installs a provider
+var tracer = otel.Tracer("geoip")
+
+// Whether a trace exporter is configured with the standard OTEL_* env vars
+func tracingEnabled() bool {
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+		os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Sets up exporting traces over OTLP/HTTP when an endpoint is configured.
+// Returns a function to flush and stop the exporter on shutdown.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !tracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", traceServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return provider.Shutdown, nil
+}
+
+// Starts the span around a database lookup of the IP
+func startLookupSpan(ctx context.Context, ip string) (context.Context, trace.Span) {
+	if traceHashIP {
+		sum := sha256.Sum256([]byte(ip))
+		ip = hex.EncodeToString(sum[:8])
+	}
+	return tracer.Start(ctx, "geoip.lookup", trace.WithAttributes(attribute.String("geoip.ip", ip)))
+}
+
+// Records the outcome of a lookup on its span and ends it
+func endLookupSpan(span trace.Span, err error) {
+	result := "found"
+	switch {
+	case errors.Is(err, errNotFound):
+		result = "not_found"
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.SetAttributes(attribute.String("geoip.result", result))
+	span.End()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Records the spans started during the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(t.Context()) })
+	setConfig(t, &tracer, provider.Tracer("geoip"))
+	return recorder
+}
+
+// Gets the string attributes of a span
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	attributes := map[attribute.Key]string{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value.AsString()
+	}
+	return attributes
+}
+
+func TestLookupSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	purgeCache()
+
+	get("/geo/zip?ip=81.2.69.142")
+	get("/geo/zip?ip=3000::1")
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want one per lookup", len(spans))
+	}
+
+	want := []map[attribute.Key]string{
+		{"geoip.ip": "81.2.69.142", "geoip.result": "found"},
+		{"geoip.ip": "3000::1", "geoip.result": "not_found"},
+	}
+	for i, span := range spans {
+		if span.Name() != "geoip.lookup" {
+			t.Errorf("got span %q, want geoip.lookup", span.Name())
+		}
+		attributes := spanAttributes(span)
+		for key, value := range want[i] {
+			if attributes[key] != value {
+				t.Errorf("got %s=%q on span %d, want %q", key, attributes[key], i, value)
+			}
+		}
+	}
+
+	// Cached lookups don't touch the database, so have no span
+	get("/geo/zip?ip=81.2.69.142")
+	if got := len(recorder.Ended()); got != 2 {
+		t.Errorf("got %d spans after a cached lookup, want 2", got)
+	}
+}
+
+func TestLookupSpanHashedIP(t *testing.T) {
+	recorder := recordSpans(t)
+	setConfig(t, &traceHashIP, true)
+	purgeCache()
+
+	get("/geo/zip?ip=81.2.69.142")
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if ip := spanAttributes(spans[0])["geoip.ip"]; ip == "81.2.69.142" || len(ip) != 16 {
+		t.Errorf("got geoip.ip %q with TRACE_HASH_IP, want a hash", ip)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version of the service, set at build time with
+// `go build -ldflags "-X main.version=1.2.3"`
+var version = "dev"
+
+// Returns the build of the service and of the database it's serving
+func versionHandler(c *gin.Context) {
+	response := gin.H{
+		"version":    version,
+		"go_version": runtime.Version(),
+	}
+
+	if metadata, ok := geoDb.Metadata(); ok {
+		response["database"] = gin.H{
+			"type":       metadata.DatabaseType,
+			"build_time": time.Unix(int64(metadata.BuildEpoch), 0).UTC().Format(time.RFC3339),
+		}
+	}
+
+	c.JSON(200, response)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVersionHandler(t *testing.T) {
+	setConfig(t, &version, "1.2.3")
+
+	var body struct {
+		Version  string `json:"version"`
+		Database struct {
+			Type      string `json:"type"`
+			BuildTime string `json:"build_time"`
+		} `json:"database"`
+	}
+	decodeResponse(t, get("/version"), 200, &body)
+
+	if body.Version != "1.2.3" {
+		t.Errorf("got version %q, want 1.2.3", body.Version)
+	}
+	if body.Database.Type != "GeoLite2-City" || body.Database.BuildTime != "2021-11-30T10:31:07Z" {
+		t.Errorf("got database %+v, want the GeoLite2-City build of 2021-11-30T10:31:07Z", body.Database)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Whether to reload the database whenever GEO_FILE changes on disk
+var watchDb = envBool("WATCH_DB", false)
+
+// How long GEO_FILE must be quiet after a change before it's reloaded, so
+// a replace made of several writes only triggers a single reload
+var watchDebounce = envDuration("WATCH_DEBOUNCE", 2*time.Second)
+
+// Watches GEO_FILE and reloads the database when it changes. The directory
+// is watched rather than the file itself so that updaters which replace
+// the file (writing a temp file and renaming it over) are picked up too.
+func watchDatabase() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(filepath.Dir(geoFile)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		var debounce *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(geoFile) ||
+					!event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+					continue
+				}
+
+				if debounce != nil {
+					debounce.Stop()
+				}
+				debounce = time.AfterFunc(watchDebounce, reloadDatabase)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("database watcher failed", "error", err)
+			}
+		}
+	}()
+
+	slog.Info("watching database for changes", "file", geoFile)
+	return watcher, nil
+}
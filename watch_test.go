@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Waits for a lookup of 81.2.69.142 to be in the country
+func waitForCountry(t *testing.T, country string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record, err := geoDb.City(net.ParseIP("81.2.69.142"))
+		if err == nil && record.Country.IsoCode == country {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the database wasn't reloaded, got %v, %v", record, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchDatabase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "GeoIP2-City.mmdb")
+	if err := os.WriteFile(path, buildTestCityDatabase(t, "FR"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig(t, &geoFile, path)
+	setConfig(t, &watchDebounce, 10*time.Millisecond)
+	setConfig(t, &dbSelfTest, &selfTest{})
+	restoreDatabase(t)
+	reloadDatabase()
+	waitForCountry(t, "FR")
+
+	watcher, err := watchDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	// Replaced the way updaters do, writing a temp file and renaming it over
+	temp := filepath.Join(dir, "GeoIP2-City.mmdb.tmp")
+	if err := os.WriteFile(temp, buildTestCityDatabase(t, "DE"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		t.Fatal(err)
+	}
+	waitForCountry(t, "DE")
+
+	// Let the reloads of any other events of the replace finish before
+	// GEO_FILE is restored
+	time.Sleep(5 * watchDebounce)
+	reloadMu.Lock()
+	reloadMu.Unlock()
+}
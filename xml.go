@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/xml"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Whether the response should be XML rather than JSON, requested with
+// `format=xml` or an `application/xml` Accept header
+func wantsXML(c *gin.Context) bool {
+	return c.Query("format") == "xml" ||
+		strings.Contains(c.GetHeader("Accept"), "application/xml")
+}
+
+// Whether the response should be indented for reading, requested with
+// `pretty=true`
+func wantsPretty(c *gin.Context) bool {
+	pretty, _ := strconv.ParseBool(c.Query("pretty"))
+	return pretty
+}
+
+// Writes a successful response as JSON, or as XML or JSONP when it's
+// requested, indented when `pretty=true`. JSON keys follow the `schema`
+// requested. XML responses are wrapped in a <response> element, with each
+// item of a list in a <result> element. When a CIDR was looked up, it's
+// echoed as the `query_network` key, or attribute for XML, apart from the
+// `network` the database matched.
+func render(c *gin.Context, code int, obj interface{}) {
+	queryNetwork := c.GetString(lookupNetworkKey)
+
+	if !wantsXML(c) {
+		callback, ok := getJSONPCallback(c)
+		if !ok {
+			return
+		}
+
+		keys, ok := getSchema(c)
+		if !ok {
+			return
+		}
+		var err error
+		if queryNetwork != "" {
+			obj, err = withField("query_network", queryNetwork, obj)
+		}
+		if err == nil {
+			obj, err = applySchema(obj, keys)
+		}
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(500, gin.H{"error": "failed to encode response"})
+			return
+		}
+
+		switch {
+		case callback != "":
+			c.JSONP(code, obj)
+		case wantsPretty(c):
+			c.IndentedJSON(code, obj)
+		default:
+			c.JSON(code, obj)
+		}
+		return
+	}
+
+	if value := reflect.ValueOf(obj); value.Kind() == reflect.Slice {
+		obj = xmlList{Results: obj}
+	}
+	if queryNetwork != "" {
+		obj = xmlResult{queryNetwork: queryNetwork, value: obj}
+	}
+
+	c.Header("Content-Type", "application/xml; charset=utf-8")
+	c.Status(code)
+	c.Writer.WriteString(xml.Header)
+	encoder := xml.NewEncoder(c.Writer)
+	if wantsPretty(c) {
+		encoder.Indent("", "    ")
+	}
+	if err := encoder.EncodeElement(xmlValue(obj), xml.StartElement{Name: xml.Name{Local: "response"}}); err != nil {
+		c.Error(err)
+	}
+}
+
+// xmlList is a list response, marshaled as a <result> element per item
+type xmlList struct {
+	Results interface{} `xml:"result"`
+}
+
+// xmlMap marshals a gin.H as an element per key, in sorted order like
+// the JSON encoding
+type xmlMap gin.H
+
+func (m xmlMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := e.EncodeElement(xmlValue(m[key]), xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xmlResult is the response for one IP of a comma-separated `ip`, or for
+// a CIDR, marshaled with the IP or network as an attribute of its element
+type xmlResult struct {
+	ip           string
+	queryNetwork string
+	value        interface{}
+}
+
+func (r xmlResult) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if r.ip != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "ip"}, Value: r.ip})
+	}
+	if r.queryNetwork != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "query_network"}, Value: r.queryNetwork})
+	}
+	return e.EncodeElement(xmlValue(r.value), start)
+}
+
+// Converts a value that encoding/xml can't marshal itself (a gin.H) into
+// one it can
+func xmlValue(obj interface{}) interface{} {
+	if h, ok := obj.(gin.H); ok {
+		return xmlMap(h)
+	}
+	return obj
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestXMLResponse(t *testing.T) {
+	req := httptest.NewRequest("GET", "/geo/zip?ip=81.2.69.142", nil)
+	req.Header.Set("Accept", "application/xml")
+
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"format parameter": get("/geo/zip?ip=81.2.69.142&format=xml"),
+		"accept header":    serveRequest(req),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := w.Header().Get("Content-Type"); got != "application/xml; charset=utf-8" {
+				t.Errorf("got Content-Type %q, want application/xml", got)
+			}
+
+			var body struct {
+				XMLName xml.Name `xml:"response"`
+				Zip     string   `xml:"zip"`
+			}
+			if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid XML %q: %v", w.Body.String(), err)
+			}
+			if body.Zip != "NR1" {
+				t.Errorf("got zip %q, want NR1", body.Zip)
+			}
+		})
+	}
+}
+
+func TestXMLList(t *testing.T) {
+	w := get("/geo/zip?ip=81.2.69.142,10.0.0.1&format=xml")
+
+	var body struct {
+		Results []struct {
+			IP    string `xml:"ip,attr"`
+			Zip   string `xml:"zip"`
+			Error string `xml:"error"`
+		} `xml:"result"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid XML %q: %v", w.Body.String(), err)
+	}
+
+	if len(body.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(body.Results))
+	}
+	if first := body.Results[0]; first.IP != "81.2.69.142" || first.Zip != "NR1" {
+		t.Errorf("got %+v first, want 81.2.69.142 in NR1", first)
+	}
+	if second := body.Results[1]; second.IP != "10.0.0.1" || second.Error != "private or reserved ip" {
+		t.Errorf("got %+v second, want an error for 10.0.0.1", second)
+	}
+}
+
+func TestPrettyResponse(t *testing.T) {
+	w := get("/geo/country?ip=81.2.69.142&pretty=true")
+	want := "{\n    \"country_code\": \"GB\",\n    \"country_name\": \"United Kingdom\",\n    \"is_eu\": false\n}"
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("got %d %q with pretty=true, want it indented", w.Code, w.Body.String())
+	}
+
+	// Responses are compact by default
+	for _, target := range []string{"/geo/country?ip=81.2.69.142", "/geo/country?ip=81.2.69.142&pretty=false"} {
+		if body := get(target).Body.String(); strings.ContainsAny(body, "\n\t") || strings.Contains(body, ": ") {
+			t.Errorf("got %q for %s, want it compact", body, target)
+		}
+	}
+}